@@ -7,22 +7,29 @@ import (
 	"os"
 
 	"go.uber.org/zap"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/state"
 )
 
 // Handlers manages the set of check endpoints.
 type Handlers struct {
 	Build string
 	Log   *zap.SugaredLogger
+	State *state.State
 }
 
-// Readiness checks if the database is ready and if not will return a 500 status.
+// Readiness checks if the node has caught up with its peers and if not will
+// return a 500 status.
 // Do not respond by just returning an error because further up in the call
 // stack it will interpret that as a non-trusted error.
 func (h Handlers) Readiness(w http.ResponseWriter, r *http.Request) {
 	status := "ok"
 	statusCode := http.StatusOK
 
-	// TODO: NOT SURE WHAT TO DO RIGHT NOW
+	if !h.State.IsSynced() {
+		status = "syncing"
+		statusCode = http.StatusInternalServerError
+	}
 
 	data := struct {
 		Status string `json:"status"`
@@ -14,14 +14,19 @@ import (
 	v1 "github.com/ardanlabs/blockchain/app/services/node/handlers/v1"
 	"github.com/ardanlabs/blockchain/business/web/v1/mid"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/state"
+	"github.com/ardanlabs/blockchain/foundation/events"
+	"github.com/ardanlabs/blockchain/foundation/nameservice"
 	"github.com/ardanlabs/blockchain/foundation/web"
 )
 
 // MuxConfig contains all the mandatory systems required by handlers.
 type MuxConfig struct {
+	Build    string
 	Shutdown chan os.Signal
 	Log      *zap.SugaredLogger
 	State    *state.State
+	NS       *nameservice.NameService
+	Evts     *events.Events
 }
 
 // PublicMux constructs a http.Handler with all application routes defined.
@@ -49,6 +54,8 @@ func PublicMux(cfg MuxConfig) http.Handler {
 	v1.PublicRoutes(app, v1.Config{
 		Log:   cfg.Log,
 		State: cfg.State,
+		NS:    cfg.NS,
+		Evts:  cfg.Evts,
 	})
 
 	return app
@@ -77,7 +84,9 @@ func PrivateMux(cfg MuxConfig) http.Handler {
 
 	// Load the v1 routes.
 	v1.PrivateRoutes(app, v1.Config{
-		Log: cfg.Log,
+		Build: cfg.Build,
+		Log:   cfg.Log,
+		State: cfg.State,
 	})
 
 	return app
@@ -105,13 +114,14 @@ func DebugStandardLibraryMux() *http.ServeMux {
 // debug application routes for the service. This bypassing the use of the
 // DefaultServerMux. Using the DefaultServerMux would be a security risk since
 // a dependency could inject a handler into our service without us knowing it.
-func DebugMux(build string, log *zap.SugaredLogger) http.Handler {
+func DebugMux(build string, log *zap.SugaredLogger, state *state.State) http.Handler {
 	mux := DebugStandardLibraryMux()
 
 	// Register debug check endpoints.
 	cgh := checkgrp.Handlers{
 		Build: build,
 		Log:   log,
+		State: state,
 	}
 	mux.HandleFunc("/debug/readiness", cgh.Readiness)
 	mux.HandleFunc("/debug/liveness", cgh.Liveness)
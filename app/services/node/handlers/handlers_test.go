@@ -0,0 +1,194 @@
+package handlers_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"go.uber.org/zap"
+
+	"github.com/ardanlabs/blockchain/app/services/node/handlers"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool/selector"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/state"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+	"github.com/ardanlabs/blockchain/foundation/nameservice"
+)
+
+func TestPrunedBlocks(t *testing.T) {
+	st, trans := newPrunedState(t)
+
+	ns, err := nameservice.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("constructing name service: %s", err)
+	}
+
+	cfg := handlers.MuxConfig{
+		Build:    "test",
+		Shutdown: make(chan os.Signal, 1),
+		Log:      zap.NewNop().Sugar(),
+		State:    st,
+		NS:       ns,
+	}
+	private := handlers.PrivateMux(cfg)
+	public := handlers.PublicMux(cfg)
+
+	tests := []struct {
+		name       string
+		mux        http.Handler
+		url        string
+		statusCode int
+		pruned     bool // The proof must only hold the header.
+	}{
+		{name: "block list with pruned blocks", mux: private, url: "/v1/node/block/list/1/latest", statusCode: http.StatusGone},
+		{name: "block list after the pruned blocks", mux: private, url: "/v1/node/block/list/4/latest", statusCode: http.StatusOK},
+		{name: "proof in a pruned block", mux: public, url: fmt.Sprintf("/v1/tx/proof/1/%s", trans[0].TxHash()), statusCode: http.StatusOK, pruned: true},
+		{name: "proof in a block with transactions", mux: public, url: fmt.Sprintf("/v1/tx/proof/5/%s", trans[4].TxHash()), statusCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+
+			if w.Code != tt.statusCode {
+				t.Fatalf("status: got %d, expected %d: %s", w.Code, tt.statusCode, w.Body)
+			}
+
+			if tt.mux == private {
+				var blocks []json.RawMessage
+				if tt.statusCode == http.StatusOK {
+					if err := json.Unmarshal(w.Body.Bytes(), &blocks); err != nil {
+						t.Fatalf("decoding blocks: %s", err)
+					}
+					if len(blocks) != 2 {
+						t.Errorf("got %d blocks, expected 2", len(blocks))
+					}
+				}
+				return
+			}
+
+			var proof struct {
+				Pruned bool            `json:"pruned"`
+				Tx     json.RawMessage `json:"tx"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &proof); err != nil {
+				t.Fatalf("decoding proof: %s", err)
+			}
+			if proof.Pruned != tt.pruned || (len(proof.Tx) == 0) != tt.pruned {
+				t.Errorf("proof: pruned %t, transaction %s", proof.Pruned, proof.Tx)
+			}
+		})
+	}
+}
+
+// =============================================================================
+
+// newPrunedState constructs a node that keeps the transactions of the last
+// two blocks and adds five blocks to it, one transaction in each. Every block
+// is written to a segment of its own, so the first three are pruned.
+func newPrunedState(t *testing.T) (*state.State, []database.BlockTx) {
+	t.Helper()
+
+	key, err := crypto.HexToECDSA("fae85851bdf5c9f49923722ce38f3c1defcfd3619ef5453230a58ad805499959")
+	if err != nil {
+		t.Fatalf("loading key: %s", err)
+	}
+
+	from, err := database.PublicKeyToAccountID(key.PublicKey)
+	if err != nil {
+		t.Fatalf("converting key: %s", err)
+	}
+
+	log, err := storage.NewLogStorage(t.TempDir(), 1, nil)
+	if err != nil {
+		t.Fatalf("opening storage: %s", err)
+	}
+
+	st, err := state.NewState(state.Config{
+		BeneficiaryID: "0xFef311483Cc040e1A89fb9bb469eeB8A70935EF8",
+		Genesis: genesis.Genesis{
+			Date:          time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC),
+			ChainID:       1,
+			TransPerBlock: 10,
+			Difficulty:    1,
+			MiningReward:  700,
+			GasPrice:      15,
+			Balances:      map[string]int64{string(from): 1_000_000},
+		},
+		PrivateKey:      key,
+		Storage:         log,
+		MemPoolStrategy: selector.StrategyTip,
+		PruneDepth:      2,
+	})
+	if err != nil {
+		t.Fatalf("constructing state: %s", err)
+	}
+	t.Cleanup(func() { st.Shutdown(context.Background()) })
+
+	var trans []database.BlockTx
+	for nonce := uint64(1); nonce <= 5; nonce++ {
+		tx := newTx(t, key, nonce)
+		if err := st.ProcessProposedBlock(mineBlock(t, st, tx)); err != nil {
+			t.Fatalf("adding block %d: %s", nonce, err)
+		}
+		trans = append(trans, tx)
+	}
+
+	return st, trans
+}
+
+// newTx signs a transfer from the key with the specified nonce.
+func newTx(t *testing.T, key *ecdsa.PrivateKey, nonce uint64) database.BlockTx {
+	t.Helper()
+
+	from, err := database.PublicKeyToAccountID(key.PublicKey)
+	if err != nil {
+		t.Fatalf("converting key: %s", err)
+	}
+
+	tx, err := database.NewTx(from, "0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76", 10, 1, 1, nil, nonce)
+	if err != nil {
+		t.Fatalf("constructing transaction: %s", err)
+	}
+
+	signedTx, err := tx.Sign(key)
+	if err != nil {
+		t.Fatalf("signing transaction: %s", err)
+	}
+
+	return database.NewBlockTx(signedTx, 15, 1)
+}
+
+// mineBlock mines the next block of the node with the transactions, the way
+// a peer would before proposing it.
+func mineBlock(t *testing.T, st *state.State, trans ...database.BlockTx) database.Block {
+	t.Helper()
+
+	latest := st.GetLastBlock()
+
+	block, err := database.POW(context.Background(), database.POWArgs{
+		BeneficiaryID: "0xFef311483Cc040e1A89fb9bb469eeB8A70935EF8",
+		Difficulty:    st.GetGenesis().Difficulty,
+		MiningReward:  uint64(st.GetGenesis().MiningReward),
+		PrevBlock:     latest,
+		StateRoot:     st.GetStateRoot(),
+		Trans:         trans,
+		Workers:       1,
+		TimeStamp:     latest.Header.TimeStamp + 1,
+		EvHandler:     func(v string, args ...any) {},
+	})
+	if err != nil {
+		t.Fatalf("mining block: %s", err)
+	}
+
+	return block
+}
@@ -3,24 +3,250 @@ package private
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 
-	"github.com/ardanlabs/blockchain/foundation/web"
 	"go.uber.org/zap"
+
+	v1Web "github.com/ardanlabs/blockchain/business/web/v1"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/peer"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/state"
+	"github.com/ardanlabs/blockchain/foundation/web"
 )
 
+// maxBlocksPerResponse limits how many blocks BlocksByNumber returns, so a
+// large range doesn't have to be held in memory. Peers ask for the blocks
+// after the last one they received until they have the range.
+const maxBlocksPerResponse = 100
+
 // Handlers manages the set of bar ledger endpoints.
 type Handlers struct {
-	Log *zap.SugaredLogger
+	Build string
+	Log   *zap.SugaredLogger
+	State *state.State
+}
+
+// CancelMining stops the mining operation in progress on this node.
+func (h Handlers) CancelMining(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	h.State.CancelMining()
+
+	resp := struct {
+		Status string `json:"status"`
+	}{
+		Status: "mining cancelled",
+	}
+
+	return web.Respond(ctx, w, resp, http.StatusOK)
 }
 
-// Sample just provides a starting point for the class.
-func (h Handlers) Sample(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+// ForceMining starts mining a block with the pending transactions right away.
+func (h Handlers) ForceMining(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	if err := h.State.ForceMining(); err != nil {
+		switch {
+		case errors.Is(err, state.ErrMiningPaused):
+			return v1Web.NewRequestError(err, http.StatusConflict)
+		case errors.Is(err, state.ErrNothingToMine):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		}
+		return fmt.Errorf("unable to force mining: %w", err)
+	}
+
+	resp := struct {
+		Status string `json:"status"`
+	}{
+		Status: "mining started",
+	}
+
+	return web.Respond(ctx, w, resp, http.StatusOK)
+}
+
+// PauseMining stops this node from mining until it's resumed.
+func (h Handlers) PauseMining(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	h.State.PauseMining()
+
 	resp := struct {
-		Status string
+		Status string `json:"status"`
 	}{
-		Status: "OK",
+		Status: "mining paused",
 	}
 
 	return web.Respond(ctx, w, resp, http.StatusOK)
 }
+
+// ResumeMining lets this node mine again after it was paused.
+func (h Handlers) ResumeMining(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	h.State.ResumeMining()
+
+	resp := struct {
+		Status string `json:"status"`
+	}{
+		Status: "mining resumed",
+	}
+
+	return web.Respond(ctx, w, resp, http.StatusOK)
+}
+
+// Status returns the state of this node. Peers use it to decide whether they
+// need to catch up and to check they are on the same chain.
+func (h Handlers) Status(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	latestBlock := h.State.GetLastBlock()
+	knownPeers := h.State.KnownExternalPeers()
+
+	status := peer.Status{
+		Build:             h.Build,
+		GenesisHash:       h.State.GetGenesis().Hash(),
+		LatestBlockHash:   latestBlock.Hash(),
+		LatestBlockNumber: latestBlock.Header.Number,
+		MempoolLength:     h.State.MempoolLength(),
+		Mining:            h.State.Worker.IsMining(),
+		MiningPaused:      h.State.Worker.IsPaused(),
+		PeerCount:         len(knownPeers),
+		KnownPeers:        knownPeers,
+	}
+
+	return web.Respond(ctx, w, status, http.StatusOK)
+}
+
+// BlocksByNumber returns the blocks between the specified numbers, both
+// included. The word latest can be used for the last number. At most
+// maxBlocksPerResponse blocks are returned from the first number on.
+func (h Handlers) BlocksByNumber(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	latest := h.State.GetLastBlock().Header.Number
+
+	from, err := strconv.ParseUint(web.Param(r, "from"), 10, 64)
+	if err != nil || from == 0 {
+		return v1Web.NewRequestError(errors.New("from must be a block number greater than 0"), http.StatusBadRequest)
+	}
+
+	to := latest
+	if toStr := web.Param(r, "to"); toStr != "latest" {
+		to, err = strconv.ParseUint(toStr, 10, 64)
+		if err != nil {
+			return v1Web.NewRequestError(errors.New("to must be a block number or latest"), http.StatusBadRequest)
+		}
+	}
+
+	if to > latest {
+		to = latest
+	}
+
+	if from <= to && to-from >= maxBlocksPerResponse {
+		to = from + maxBlocksPerResponse - 1
+	}
+
+	blocksData := make([]database.BlockData, 0, maxBlocksPerResponse)
+	if from > to {
+		return web.Respond(ctx, w, blocksData, http.StatusOK)
+	}
+
+	it := h.State.Db.Blocks(from, to)
+	for it.Next() {
+		blocksData = append(blocksData, database.NewBlockData(it.Block()))
+	}
+	if err := it.Err(); err != nil {
+		if errors.Is(err, database.ErrPruned) {
+			return v1Web.NewRequestError(fmt.Errorf("this node is pruned and can't serve the blocks: %w", err), http.StatusGone)
+		}
+		return fmt.Errorf("unable to read blocks %d to %d: %w", from, to, err)
+	}
+
+	return web.Respond(ctx, w, blocksData, http.StatusOK)
+}
+
+// SubmitNodeTransaction adds a transaction shared by a peer to the mempool.
+func (h Handlers) SubmitNodeTransaction(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var tx database.BlockTx
+	if err := web.Decode(r, &tx); err != nil {
+		return v1Web.NewRequestError(fmt.Errorf("unable to decode payload: %w", err), http.StatusBadRequest)
+	}
+
+	if err := h.State.SubmitNodeTx(tx); err != nil {
+		switch {
+		case state.IsTxRejected(err):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, state.ErrShuttingDown):
+			return v1Web.NewRequestError(err, http.StatusServiceUnavailable)
+		}
+		return fmt.Errorf("unable to add transaction to mempool: %w", err)
+	}
+
+	resp := struct {
+		Status string `json:"status"`
+	}{
+		Status: "added",
+	}
+
+	return web.Respond(ctx, w, resp, http.StatusOK)
+}
+
+// ProposeBlock takes a block mined by a peer and adds it to the chain.
+func (h Handlers) ProposeBlock(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var blockData database.BlockData
+	if err := web.Decode(r, &blockData); err != nil {
+		return v1Web.NewRequestError(fmt.Errorf("unable to decode payload: %w", err), http.StatusBadRequest)
+	}
+
+	block, err := database.ToBlock(blockData)
+	if err != nil {
+		return v1Web.NewRequestError(fmt.Errorf("unable to decode block: %w", err), http.StatusBadRequest)
+	}
+
+	if err := h.State.ProcessProposedBlock(block); err != nil {
+		if errors.Is(err, state.ErrShuttingDown) {
+			return v1Web.NewRequestError(err, http.StatusServiceUnavailable)
+		}
+		return v1Web.NewRequestError(fmt.Errorf("block not accepted: %w", err), http.StatusNotAcceptable)
+	}
+
+	resp := struct {
+		Status string `json:"status"`
+	}{
+		Status: "accepted",
+	}
+
+	return web.Respond(ctx, w, resp, http.StatusOK)
+}
+
+// Peers returns the known peers and what this node knows about their health.
+func (h Handlers) Peers(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	return web.Respond(ctx, w, h.State.KnownPeers.Info(h.State.Host), http.StatusOK)
+}
+
+// AddPeer adds a peer to the known peers. Nodes call this to announce
+// themselves and admins can call it to grow the cluster.
+func (h Handlers) AddPeer(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var pr peer.Peer
+	if err := web.Decode(r, &pr); err != nil {
+		return v1Web.NewRequestError(fmt.Errorf("unable to decode payload: %w", err), http.StatusBadRequest)
+	}
+
+	if pr.Host == "" {
+		return v1Web.NewRequestError(errors.New("host is required"), http.StatusBadRequest)
+	}
+
+	if pr.Match(h.State.Host) {
+		return v1Web.NewRequestError(errors.New("a node can't be its own peer"), http.StatusBadRequest)
+	}
+
+	if h.State.KnownPeers.Add(pr) {
+		h.Log.Infow("add peer", "traceid", web.GetTraceID(ctx), "host", pr.Host)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// RemovePeer removes a peer from the known peers.
+func (h Handlers) RemovePeer(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	pr := peer.New(web.Param(r, "host"))
+
+	if !h.State.KnownPeers.Remove(pr) {
+		return v1Web.NewRequestError(fmt.Errorf("peer %s is not known", pr.Host), http.StatusNotFound)
+	}
+
+	h.Log.Infow("remove peer", "traceid", web.GetTraceID(ctx), "host", pr.Host)
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
@@ -9,6 +9,7 @@ type accountDTO struct {
 	Name    string             `json:"name"`
 	Balance int64              `json:"balance"`
 	Nonce   uint64             `json:"nonce"`
+	Stake   uint64             `json:"stake,omitempty"`
 }
 
 type accountInfoDTO struct {
@@ -18,6 +19,7 @@ type accountInfoDTO struct {
 }
 
 type txDTO struct {
+	Hash        string             `json:"hash"`
 	FromAccount database.AccountID `json:"from"`
 	FromName    string             `json:"from_name"`
 	To          database.AccountID `json:"to"`
@@ -33,6 +35,34 @@ type txDTO struct {
 	Sig         string             `json:"sig"`
 }
 
+// toTxDTO converts a pending transaction into the value returned to clients.
+func (h Handlers) toTxDTO(tx database.BlockTx) txDTO {
+	return txDTO{
+		Hash:        tx.TxHash(),
+		FromAccount: tx.FromID,
+		FromName:    h.NS.Lookup(tx.FromID),
+		To:          tx.ToID,
+		ToName:      h.NS.Lookup(tx.ToID),
+		ChainID:     tx.ChainId,
+		Nonce:       tx.Nonce,
+		Value:       tx.Value,
+		Tip:         tx.Tip,
+		Data:        tx.Data,
+		TimeStamp:   tx.TimeStamp,
+		GasPrice:    tx.GasPrice,
+		GasUnits:    tx.GasUnits,
+		Sig:         tx.SignatureString(),
+	}
+}
+
 type badRequest struct {
 	Err string `json:"error"`
 }
+
+type txProofDTO struct {
+	Header database.BlockHeader `json:"header"`
+	Pruned bool                 `json:"pruned,omitempty"`
+	Tx     *txDTO               `json:"tx,omitempty"`
+	Proof  []string             `json:"proof,omitempty"`
+	Order  []int64              `json:"order,omitempty"`
+}
@@ -4,13 +4,20 @@ package public
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	"emperror.dev/errors"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"go.uber.org/zap"
 
+	v1Web "github.com/ardanlabs/blockchain/business/web/v1"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/state"
+	"github.com/ardanlabs/blockchain/foundation/events"
+	"github.com/ardanlabs/blockchain/foundation/nameservice"
 	"github.com/ardanlabs/blockchain/foundation/web"
 )
 
@@ -21,6 +28,8 @@ const FIXED_GAS_AMOUNT = 1
 type Handlers struct {
 	Log   *zap.SugaredLogger
 	State *state.State
+	NS    *nameservice.NameService
+	Evts  *events.Events
 }
 
 // Sample just provides a starting point for the class.
@@ -67,8 +76,10 @@ func (h Handlers) GetAccounts(ctx context.Context, w http.ResponseWriter, r *htt
 	for account, info := range accounts {
 		act := accountDTO{
 			Account: account,
+			Name:    h.NS.Lookup(account),
 			Balance: info.Balance,
 			Nonce:   info.Nonce,
+			Stake:   info.Stake,
 		}
 		resp = append(resp, act)
 	}
@@ -76,28 +87,95 @@ func (h Handlers) GetAccounts(ctx context.Context, w http.ResponseWriter, r *htt
 	return web.Respond(ctx, w, resp, http.StatusOK)
 }
 
+// MemPool returns the pending transactions, optionally only the ones sent by
+// the specified account.
 func (h Handlers) MemPool(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	mempool := h.State.Mempool()
+	accountStr := web.Param(r, "account")
 
-	var resultTx = make([]txDTO, 0, len(mempool))
+	var mempool []database.BlockTx
+	switch accountStr {
+	case "":
+		mempool = h.State.Mempool()
 
+	default:
+		accountID, err := database.ToAccountID(accountStr)
+		if err != nil {
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		}
+		mempool = h.State.MempoolForAccount(accountID)
+	}
+
+	resp := make([]txDTO, 0, len(mempool))
 	for _, tx := range mempool {
-		resultTx = append(resultTx, txDTO{
-			FromAccount: tx.FromID,
-			To:          tx.ToID,
-			Value:       tx.Value,
-			Nonce:       tx.Nonce,
-			ChainID:     tx.ChainId,
-			Tip:         tx.Tip,
-			GasPrice:    tx.GasPrice,
-			GasUnits:    tx.GasUnits,
-			Data:        tx.Data,
-			TimeStamp:   tx.TimeStamp,
-			Sig:         tx.SignatureString(),
-		})
-	}
-
-	return web.Respond(ctx, w, mempool, http.StatusOK)
+		resp = append(resp, h.toTxDTO(tx))
+	}
+
+	return web.Respond(ctx, w, resp, http.StatusOK)
+}
+
+// MemPoolTx returns the pending transaction with the specified hash.
+func (h Handlers) MemPoolTx(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	hash := web.Param(r, "hash")
+
+	tx, exists := h.State.MempoolTx(hash)
+	if !exists {
+		return v1Web.NewRequestError(fmt.Errorf("transaction %s is not pending", hash), http.StatusNotFound)
+	}
+
+	return web.Respond(ctx, w, h.toTxDTO(tx), http.StatusOK)
+}
+
+// TxProof returns the merkle proof that the transaction with the specified
+// hash is in the block, along with the block header to check it against. A
+// pruned block has no transactions left, so only its header is returned.
+func (h Handlers) TxProof(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	number, err := strconv.ParseUint(web.Param(r, "block"), 10, 64)
+	if err != nil || number == 0 {
+		return v1Web.NewRequestError(errors.New("block must be a block number greater than 0"), http.StatusBadRequest)
+	}
+	hash := web.Param(r, "hash")
+
+	if number > h.State.GetLastBlock().Header.Number {
+		return v1Web.NewRequestError(fmt.Errorf("block %d not found", number), http.StatusNotFound)
+	}
+
+	header, err := h.State.Db.GetHeader(number)
+	if err != nil {
+		return fmt.Errorf("unable to find block %d: %w", number, err)
+	}
+
+	block, err := h.State.Db.GetBlock(number)
+	switch {
+	case errors.Is(err, database.ErrPruned):
+		return web.Respond(ctx, w, txProofDTO{Header: header, Pruned: true}, http.StatusOK)
+	case err != nil:
+		return fmt.Errorf("unable to find block %d: %w", number, err)
+	}
+
+	for _, tx := range block.MerkleTree.Values() {
+		if tx.TxHash() != hash {
+			continue
+		}
+
+		proof, order, err := block.MerkleTree.Proof(tx)
+		if err != nil {
+			return fmt.Errorf("unable to build proof: %w", err)
+		}
+
+		resp := txProofDTO{
+			Header: header,
+			Order:  order,
+		}
+		dto := h.toTxDTO(tx)
+		resp.Tx = &dto
+		for _, p := range proof {
+			resp.Proof = append(resp.Proof, hexutil.Encode(p))
+		}
+
+		return web.Respond(ctx, w, resp, http.StatusOK)
+	}
+
+	return v1Web.NewRequestError(fmt.Errorf("transaction %s is not in block %d", hash, number), http.StatusNotFound)
 }
 
 func (h Handlers) SubmitWalletTransaction(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
@@ -107,10 +185,14 @@ func (h Handlers) SubmitWalletTransaction(ctx context.Context, w http.ResponseWr
 		return fmt.Errorf("unable to decode payload: %w", err)
 	}
 
-	err := h.State.SubmitTx(signedTx)
-	if err != nil {
-		h.Log.Error(errors.Wrap(err, "h.State.SubmitTx"))
-		return web.Respond(ctx, w, nil, http.StatusInternalServerError)
+	if err := h.State.SubmitTx(signedTx); err != nil {
+		switch {
+		case state.IsTxRejected(err):
+			return v1Web.NewRequestError(err, http.StatusBadRequest)
+		case errors.Is(err, state.ErrShuttingDown):
+			return v1Web.NewRequestError(err, http.StatusServiceUnavailable)
+		}
+		return errors.Wrap(err, "h.State.SubmitTx")
 	}
 
 	resp := struct {
@@ -122,7 +204,62 @@ func (h Handlers) SubmitWalletTransaction(ctx context.Context, w http.ResponseWr
 	return web.Respond(ctx, w, resp, http.StatusOK)
 }
 
-func (h Handlers) Cancel(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	h.State.Cancel()
-	return nil
+// Events streams the node events to the client using server-sent events.
+// The connection is hijacked so the stream isn't cut by the server's write
+// timeout.
+func (h Handlers) Events(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return errors.New("streaming is not supported")
+	}
+
+	conn, bufrw, err := hj.Hijack()
+	if err != nil {
+		return errors.Wrap(err, "hijacking connection")
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return errors.Wrap(err, "clearing deadline")
+	}
+
+	// Detect the client going away since nothing is read from the hijacked
+	// connection otherwise.
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, bufrw)
+		close(closed)
+	}()
+
+	id := web.GetTraceID(ctx)
+	ch := h.Evts.Acquire(id)
+	defer h.Evts.Release(id)
+
+	bufrw.WriteString("HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\nCache-Control: no-cache\r\nConnection: close\r\n\r\n")
+	if err := bufrw.Flush(); err != nil {
+		return nil
+	}
+
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg, wd := <-ch:
+			if !wd {
+				return nil
+			}
+			fmt.Fprintf(bufrw, "data: %s\n\n", msg)
+
+		case <-ticker.C:
+			bufrw.WriteString(": ping\n\n")
+
+		case <-closed:
+			return nil
+		}
+
+		if err := bufrw.Flush(); err != nil {
+			return nil
+		}
+	}
 }
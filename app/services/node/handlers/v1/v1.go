@@ -10,6 +10,8 @@ import (
 	"github.com/ardanlabs/blockchain/app/services/node/handlers/v1/private"
 	"github.com/ardanlabs/blockchain/app/services/node/handlers/v1/public"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/state"
+	"github.com/ardanlabs/blockchain/foundation/events"
+	"github.com/ardanlabs/blockchain/foundation/nameservice"
 	"github.com/ardanlabs/blockchain/foundation/web"
 )
 
@@ -17,8 +19,11 @@ const version = "v1"
 
 // Config contains all the mandatory systems required by handlers.
 type Config struct {
+	Build string
 	Log   *zap.SugaredLogger
 	State *state.State
+	NS    *nameservice.NameService
+	Evts  *events.Events
 }
 
 // PublicRoutes binds all the version 1 public routes.
@@ -26,6 +31,8 @@ func PublicRoutes(app *web.App, cfg Config) {
 	pbl := public.Handlers{
 		Log:   cfg.Log,
 		State: cfg.State,
+		NS:    cfg.NS,
+		Evts:  cfg.Evts,
 	}
 
 	app.Handle(http.MethodGet, version, "/sample", pbl.Sample)
@@ -34,15 +41,29 @@ func PublicRoutes(app *web.App, cfg Config) {
 	app.Handle(http.MethodGet, version, "/accounts/list/:account", pbl.GetAccounts)
 	app.Handle(http.MethodGet, version, "/tx/uncommitted/list", pbl.MemPool)
 	app.Handle(http.MethodGet, version, "/tx/uncommitted/list/:account", pbl.MemPool)
+	app.Handle(http.MethodGet, version, "/tx/uncommitted/hash/:hash", pbl.MemPoolTx)
+	app.Handle(http.MethodGet, version, "/tx/proof/:block/:hash", pbl.TxProof)
 	app.Handle(http.MethodPost, version, "/tx/submit", pbl.SubmitWalletTransaction)
-	app.Handle(http.MethodPost, version, "/tx/cancel", pbl.Cancel)
+	app.Handle(http.MethodGet, version, "/events", pbl.Events)
 }
 
 // PrivateRoutes binds all the version 1 private routes.
 func PrivateRoutes(app *web.App, cfg Config) {
 	prv := private.Handlers{
-		Log: cfg.Log,
+		Build: cfg.Build,
+		Log:   cfg.Log,
+		State: cfg.State,
 	}
 
-	app.Handle(http.MethodGet, version, "/node/sample", prv.Sample)
+	app.Handle(http.MethodPost, version, "/node/mining/cancel", prv.CancelMining)
+	app.Handle(http.MethodPost, version, "/node/mining/force", prv.ForceMining)
+	app.Handle(http.MethodPost, version, "/node/mining/pause", prv.PauseMining)
+	app.Handle(http.MethodPost, version, "/node/mining/resume", prv.ResumeMining)
+	app.Handle(http.MethodGet, version, "/node/status", prv.Status)
+	app.Handle(http.MethodGet, version, "/node/block/list/:from/:to", prv.BlocksByNumber)
+	app.Handle(http.MethodPost, version, "/node/tx/submit", prv.SubmitNodeTransaction)
+	app.Handle(http.MethodPost, version, "/node/block/propose", prv.ProposeBlock)
+	app.Handle(http.MethodGet, version, "/node/peers", prv.Peers)
+	app.Handle(http.MethodPost, version, "/node/peers", prv.AddPeer)
+	app.Handle(http.MethodDelete, version, "/node/peers/:host", prv.RemovePeer)
 }
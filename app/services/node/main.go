@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -15,11 +16,18 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/ardanlabs/blockchain/app/services/node/handlers"
+	"github.com/ardanlabs/blockchain/business/web/metrics"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/consensus"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool/selector"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/peer"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/state"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/worker"
+	"github.com/ardanlabs/blockchain/foundation/events"
 	"github.com/ardanlabs/blockchain/foundation/logger"
+	"github.com/ardanlabs/blockchain/foundation/nameservice"
 )
 
 // build is the git version of this program. It is set using build flags in the makefile.
@@ -63,8 +71,24 @@ func run(log *zap.SugaredLogger) error {
 			PrivateHost     string        `conf:"default:0.0.0.0:9080"`
 		}
 		State struct {
-			Beneficiary     string `conf:"default:miner1"` // Change to POA to run Proof of Authority
-			MemPoolStrategy string `conf:"default:tip"`
+			Beneficiary        string        `conf:"default:miner1"` // Signs the blocks when genesis selects POA or POS
+			MemPoolStrategy    string        `conf:"default:tip"`    // tip, tip_advanced or fee_density
+			MinTip             uint64        `conf:"default:0"`
+			MaxBlockGas        uint64        `conf:"default:1000000"` // fee_density only. 0 doesn't limit the gas
+			MaxBlockBytes      uint64        `conf:"default:1048576"` // fee_density only. 0 doesn't limit the size
+			DBPath             string        `conf:"default:zblock/miner1/"`
+			DBType             string        `conf:"default:disk"`  // disk stores a file per block, log appends to segment files
+			DBRepair           bool          `conf:"default:false"` // Truncate the chain back to the last good block
+			PruneDepth         uint64        `conf:"default:0"`     // Recent blocks that keep their transactions. 0 keeps every block
+			OriginPeers        []string      `conf:"default:0.0.0.0:9080"`
+			PeerUpdateInterval time.Duration `conf:"default:10s"`
+			MaxPeerFailures    int           `conf:"default:3"`
+			MiningWorkers      int           `conf:"default:0"`     // 0 uses every CPU
+			BlockInterval      time.Duration `conf:"default:0s"`    // 0 only mines when the mempool is full
+			DevMode            bool          `conf:"default:false"` // Seal instantly with in-memory storage and funded accounts
+			DevBalance         int64         `conf:"default:1000000"`
+			MempoolJournal     string        `conf:"default:zblock/miner1.journal"` // Empty disables saving the mempool
+			ShutdownTimeout    time.Duration `conf:"default:30s"`
 		}
 		NameService struct {
 			Folder string `conf:"default:zblock/accounts/"`
@@ -111,6 +135,17 @@ func run(log *zap.SugaredLogger) error {
 	// =========================================================================
 	// Blockchain Support
 
+	// The nameservice maps the accounts in the accounts folder to the names
+	// of their key files for nicer output.
+	ns, err := nameservice.New(cfg.NameService.Folder)
+	if err != nil {
+		return fmt.Errorf("unable to load account name service: %w", err)
+	}
+
+	for account, name := range ns.Copy() {
+		log.Infow("startup", "status", "nameservice", "name", name, "account", account)
+	}
+
 	// Need to load the private key file for the configured beneficiary so the
 	// account can get credited with fees and tips.
 	path := fmt.Sprintf("%s%s.ecdsa", cfg.NameService.Folder, cfg.State.Beneficiary)
@@ -119,9 +154,17 @@ func run(log *zap.SugaredLogger) error {
 		return fmt.Errorf("unable to load private key for node: %w", err)
 	}
 
+	// The events value is used to stream node events to clients. Only the
+	// events with the viewer prefix are sent.
+	evts := events.New()
 	ev := func(v string, args ...any) {
+		const eventsPrefix = "viewer:"
+
 		s := fmt.Sprintf(v, args...)
 		log.Infow(s, "traceid", "00000000-0000-0000-0000-000000000000")
+		if strings.HasPrefix(s, eventsPrefix) {
+			evts.Send(s)
+		}
 	}
 
 	// Load the genesis file for blockchain settings and origin balances.
@@ -137,20 +180,111 @@ func run(log *zap.SugaredLogger) error {
 		return err
 	}
 
+	// Construct the storage for the blocks this node has mined or
+	// received from peers.
+	var blockStorage database.Storage
+	switch {
+	case cfg.State.DevMode:
+
+		// Development mode seals blocks instantly and starts from a fresh
+		// chain every time where every account in the accounts folder has
+		// funds to spend. The genesis hash changes, so real nodes won't
+		// accept this node as a peer.
+		genesisN.Consensus = consensus.DEV
+		genesisN.Difficulty = 0
+
+		balances := make(map[string]int64, len(genesisN.Balances))
+		for account, balance := range genesisN.Balances {
+			balances[account] = balance
+		}
+		for account, name := range ns.Copy() {
+			if balances[string(account)] < cfg.State.DevBalance {
+				balances[string(account)] = cfg.State.DevBalance
+			}
+			log.Infow("startup", "status", "dev mode funded account", "name", name, "account", account, "balance", balances[string(account)])
+		}
+		genesisN.Balances = balances
+
+		cfg.State.MempoolJournal = ""
+
+		blockStorage = storage.NewMemoryStorage()
+
+	default:
+		var repairable interface {
+			database.Storage
+			Truncate() (uint64, error)
+		}
+
+		switch cfg.State.DBType {
+		case "disk":
+			diskStorage, err := storage.NewDiskStorage(cfg.State.DBPath)
+			if err != nil {
+				return err
+			}
+			repairable = diskStorage
+
+		case "log":
+			logStorage, err := storage.NewLogStorage(cfg.State.DBPath, storage.DefaultSegmentSize, ev)
+			if err != nil {
+				return err
+			}
+			repairable = logStorage
+
+		default:
+			return fmt.Errorf("unknown block storage type %q", cfg.State.DBType)
+		}
+		blockStorage = repairable
+
+		if cfg.State.DBRepair {
+			lastGood, err := repairable.Truncate()
+			if err != nil {
+				return fmt.Errorf("repairing block storage: %w", err)
+			}
+			log.Infow("startup", "status", "block storage repaired", "lastGoodBlock", lastGood)
+		}
+	}
+
+	// The peer set holds the nodes this node talks to, starting with the
+	// origin peers from configuration.
+	peerSet := peer.NewSet()
+	for _, host := range cfg.State.OriginPeers {
+		peerSet.AddSeed(peer.New(host))
+	}
+
 	// The state value represents the blockchain node and manages the blockchain
 	// database and provides an API for application support.
 	state, err := state.NewState(state.Config{
 		BeneficiaryID:   BeneficiaryID,
+		Host:            cfg.Web.PrivateHost,
+		KnownPeers:      peerSet,
 		Genesis:         genesisN,
+		PrivateKey:      privateKey,
+		Storage:         blockStorage,
 		EvHandler:       ev,
 		MemPoolStrategy: cfg.State.MemPoolStrategy,
+		MinTip:          cfg.State.MinTip,
+		BlockLimits: selector.Limits{
+			MaxGas:   cfg.State.MaxBlockGas,
+			MaxBytes: cfg.State.MaxBlockBytes,
+		},
+		MempoolJournal: cfg.State.MempoolJournal,
+		PruneDepth:     cfg.State.PruneDepth,
 	})
 	if err != nil {
+		if errors.Is(err, storage.ErrCorrupted) {
+			return fmt.Errorf("%w: restart with --state-db-repair to truncate the chain to the last good block", err)
+		}
 		return err
 	}
-	defer state.Shutdown()
-
-	worker.Init(state, ev)
+	log.Infow("startup", "status", "consensus", "mechanism", state.Consensus.Name())
+
+	worker.Init(state, ev, worker.Config{
+		PeerUpdateInterval: cfg.State.PeerUpdateInterval,
+		MaxPeerFailures:    cfg.State.MaxPeerFailures,
+		MiningWorkers:      cfg.State.MiningWorkers,
+		BlockInterval:      cfg.State.BlockInterval,
+		Metrics:            metrics.Mining{},
+	})
 
 	// =========================================================================
 	// Start Debug Service
@@ -161,7 +295,7 @@ func run(log *zap.SugaredLogger) error {
 	// related endpoints. This includes the standard library endpoints.
 
 	// Construct the mux for the debug calls.
-	debugMux := handlers.DebugMux(build, log)
+	debugMux := handlers.DebugMux(build, log, state)
 
 	// Start the service listening for debug requests.
 	// Not concerned with shutting this down with load shedding.
@@ -193,6 +327,8 @@ func run(log *zap.SugaredLogger) error {
 		Shutdown: shutdown,
 		Log:      log,
 		State:    state,
+		NS:       ns,
+		Evts:     evts,
 	})
 
 	// Construct a server to service the requests against the mux.
@@ -218,8 +354,10 @@ func run(log *zap.SugaredLogger) error {
 
 	// Construct the mux for the private API calls.
 	privateMux := handlers.PrivateMux(handlers.MuxConfig{
+		Build:    build,
 		Shutdown: shutdown,
 		Log:      log,
+		State:    state,
 	})
 
 	// Construct a server to service the requests against the mux.
@@ -244,12 +382,33 @@ func run(log *zap.SugaredLogger) error {
 	// Blocking main and waiting for shutdown.
 	select {
 	case err := <-serverErrors:
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.State.ShutdownTimeout)
+		defer cancel()
+
+		if err := state.Shutdown(ctx); err != nil {
+			log.Errorw("shutdown", "status", "could not stop node gracefully", "ERROR", err)
+		}
+
 		return fmt.Errorf("server error: %w", err)
 
 	case sig := <-shutdown:
 		log.Infow("shutdown", "status", "shutdown started", "signal", sig)
 		defer log.Infow("shutdown", "status", "shutdown complete", "signal", sig)
 
+		// Stop the node before the APIs so nothing is written to the chain
+		// while it's being flushed. Requests that arrive in the meantime are
+		// refused.
+		log.Infow("shutdown", "status", "shutdown node started")
+		ctx, cancelState := context.WithTimeout(context.Background(), cfg.State.ShutdownTimeout)
+		defer cancelState()
+
+		if err := state.Shutdown(ctx); err != nil {
+			log.Errorw("shutdown", "status", "could not stop node gracefully", "ERROR", err)
+		}
+
+		// Release any event streams so the public API can shut down.
+		evts.Shutdown()
+
 		// Give outstanding requests a deadline for completion.
 		ctx, cancelPub := context.WithTimeout(context.Background(), cfg.Web.ShutdownTimeout)
 		defer cancelPub()
@@ -0,0 +1,341 @@
+// This program exports the chain of a node into a portable file and imports
+// such a file into an empty data directory. Every imported block goes through
+// the same validation as a block proposed by a peer, so the result can seed a
+// new node, archive the ledger or reproduce a problem locally.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool/selector"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/state"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+)
+
+// exportFormat identifies a chain file and its version. The version changes
+// when the layout of the records does.
+const (
+	exportFormat  = "ardan-chain"
+	exportVersion = 1
+)
+
+// record is a single line of a chain file. The first line carries the
+// header, then one line per block and the last line carries the footer.
+type record struct {
+	Header *exportHeader       `json:"header,omitempty"`
+	Block  *database.BlockData `json:"block,omitempty"`
+	Footer *exportFooter       `json:"footer,omitempty"`
+}
+
+// exportHeader describes the chain the blocks belong to.
+type exportHeader struct {
+	Format      string          `json:"format"`
+	Version     int             `json:"version"`
+	GenesisHash string          `json:"genesis_hash"`
+	Genesis     genesis.Genesis `json:"genesis"`
+}
+
+// exportFooter marks the end of the file, so a truncated file is caught.
+type exportFooter struct {
+	Blocks        uint64 `json:"blocks"`
+	LastBlockHash string `json:"last_block_hash"`
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = runExport(os.Args[2:])
+	case "import":
+		err = runImport(os.Args[2:])
+	default:
+		usage()
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: chain export|import [flags]")
+	os.Exit(2)
+}
+
+// =============================================================================
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dbType := fs.String("db-type", "disk", "type of the block storage to read: disk or log")
+	dbPath := fs.String("db-path", "zblock/miner1/", "folder of the block storage to read")
+	file := fs.String("file", "zblock/chain.jsonl", "chain file to write")
+	fs.Parse(args)
+
+	gen, err := genesis.Load()
+	if err != nil {
+		return fmt.Errorf("loading genesis: %w", err)
+	}
+
+	blockStorage, err := openExportStorage(*dbType, *dbPath)
+	if err != nil {
+		return err
+	}
+	defer blockStorage.Close()
+
+	// Write to a temporary file first, so a failed export doesn't leave a
+	// chain file behind that looks usable.
+	tmp := *file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+
+	header := exportHeader{
+		Format:      exportFormat,
+		Version:     exportVersion,
+		GenesisHash: gen.Hash(),
+		Genesis:     gen,
+	}
+	if err := enc.Encode(record{Header: &header}); err != nil {
+		return err
+	}
+
+	var footer exportFooter
+	it := blockStorage.Iterate(1, database.Latest)
+	for it.Next() {
+		block := it.Block()
+		blockData := database.NewBlockData(block)
+		if err := enc.Encode(record{Block: &blockData}); err != nil {
+			return err
+		}
+		footer.Blocks++
+		footer.LastBlockHash = block.Hash()
+	}
+	if err := it.Err(); err != nil {
+		if errors.Is(err, database.ErrPruned) {
+			return fmt.Errorf("the chain is pruned, only a full chain can be exported: %w", err)
+		}
+		return fmt.Errorf("reading blocks: %w", err)
+	}
+
+	if err := enc.Encode(record{Footer: &footer}); err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, *file); err != nil {
+		return err
+	}
+
+	fmt.Printf("exported %d blocks from %s to %s\n", footer.Blocks, *dbPath, *file)
+
+	return nil
+}
+
+// =============================================================================
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dbType := fs.String("db-type", "disk", "type of the block storage to create: disk or log")
+	dbPath := fs.String("db-path", "zblock/miner1-import/", "folder of the block storage to create, must be empty")
+	file := fs.String("file", "zblock/chain.jsonl", "chain file to read")
+	verbose := fs.Bool("v", false, "log every imported block")
+	fs.Parse(args)
+
+	if entries, err := os.ReadDir(*dbPath); err == nil && len(entries) > 0 {
+		return fmt.Errorf("destination %s is not empty", *dbPath)
+	}
+
+	gen, err := genesis.Load()
+	if err != nil {
+		return fmt.Errorf("loading genesis: %w", err)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+
+	var rec record
+	if err := dec.Decode(&rec); err != nil {
+		return fmt.Errorf("reading header: %w", err)
+	}
+	if rec.Header == nil || rec.Header.Format != exportFormat {
+		return errors.New("not a chain file: header is missing")
+	}
+	if rec.Header.Version != exportVersion {
+		return fmt.Errorf("chain file version %d is not supported", rec.Header.Version)
+	}
+	if rec.Header.GenesisHash != gen.Hash() {
+		return fmt.Errorf("chain file belongs to genesis %s, this node uses %s", rec.Header.GenesisHash, gen.Hash())
+	}
+
+	blockStorage, err := openStorage(*dbType, *dbPath)
+	if err != nil {
+		return err
+	}
+
+	// The consensus engines for POA and POS want a key to seal blocks. The
+	// import only verifies blocks, so any key will do.
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		blockStorage.Close()
+		return err
+	}
+
+	ev := func(v string, args ...any) {
+		if *verbose {
+			log.Printf(v, args...)
+		}
+	}
+
+	st, err := state.NewState(state.Config{
+		Genesis:         gen,
+		PrivateKey:      privateKey,
+		Storage:         blockStorage,
+		EvHandler:       ev,
+		MemPoolStrategy: selector.StrategyTip,
+	})
+	if err != nil {
+		blockStorage.Close()
+		return err
+	}
+
+	imported, err := importBlocks(st, dec)
+	if cerr := st.Db.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("imported %d blocks from %s to %s, state root %s\n", imported, *file, *dbPath, st.GetStateRoot())
+
+	return nil
+}
+
+// importBlocks reads the blocks up to the footer and adds them to the chain
+// one at a time.
+func importBlocks(st *state.State, dec *json.Decoder) (uint64, error) {
+	if st.GetLastBlock().Header.Number != 0 {
+		return 0, errors.New("destination already holds blocks")
+	}
+
+	var imported uint64
+	var footer *exportFooter
+	for footer == nil {
+		var rec record
+		if err := dec.Decode(&rec); err != nil {
+			if errors.Is(err, io.EOF) {
+				return imported, fmt.Errorf("chain file is truncated after %d blocks", imported)
+			}
+			return imported, fmt.Errorf("reading record %d: %w", imported+1, err)
+		}
+
+		switch {
+		case rec.Block != nil:
+			block, err := database.ToBlock(*rec.Block)
+			if err != nil {
+				return imported, fmt.Errorf("decoding block %d: %w", rec.Block.Header.Number, err)
+			}
+
+			// The hash is stored next to the header, so it must match what
+			// the header produces.
+			if block.Hash() != rec.Block.Hash {
+				return imported, fmt.Errorf("block %d: hash %s doesn't match its header %s", block.Header.Number, rec.Block.Hash, block.Hash())
+			}
+
+			// UpdateBlock checks the linkage, the merkle root, the
+			// transaction signatures, the state root and the consensus
+			// rules before the block is applied.
+			if err := st.UpdateBlock(&block); err != nil {
+				return imported, fmt.Errorf("block %d: %w", block.Header.Number, err)
+			}
+			imported++
+
+		case rec.Footer != nil:
+			footer = rec.Footer
+
+		default:
+			return imported, fmt.Errorf("unexpected record after block %d", imported)
+		}
+	}
+
+	if footer.Blocks != imported {
+		return imported, fmt.Errorf("chain file lists %d blocks, %d were imported", footer.Blocks, imported)
+	}
+	if lastHash := st.GetLastBlock().Hash(); imported > 0 && footer.LastBlockHash != lastHash {
+		return imported, fmt.Errorf("chain file ends with block %s, imported chain ends with %s", footer.LastBlockHash, lastHash)
+	}
+
+	return imported, nil
+}
+
+// =============================================================================
+
+// openExportStorage opens the block storage to export without changing
+// anything in it, since a node may still be running on it. Opening the log
+// the way the node does would run its recovery, which can truncate the
+// segments the node is writing to.
+func openExportStorage(dbType string, dbPath string) (database.Storage, error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, err
+	}
+
+	switch dbType {
+	case "disk":
+		return storage.NewDiskStorage(dbPath)
+
+	case "log":
+		return storage.OpenLogStorageReadOnly(dbPath)
+	}
+
+	return nil, fmt.Errorf("unknown block storage type %q", dbType)
+}
+
+// openStorage opens the block storage the same way the node does.
+func openStorage(dbType string, dbPath string) (database.Storage, error) {
+	switch dbType {
+	case "disk":
+		return storage.NewDiskStorage(dbPath)
+
+	case "log":
+		ev := func(v string, args ...any) {
+			log.Printf(v, args...)
+		}
+		return storage.NewLogStorage(dbPath, storage.DefaultSegmentSize, ev)
+	}
+
+	return nil, fmt.Errorf("unknown block storage type %q", dbType)
+}
@@ -0,0 +1,99 @@
+// This program copies the blocks from the file per block disk storage into
+// the segmented log storage, so a node can switch to --state-db-type=log.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+)
+
+var (
+	from        string
+	to          string
+	segmentSize int64
+)
+
+func init() {
+	flag.StringVar(&from, "from", "zblock/miner1/", "folder of the disk storage to read")
+	flag.StringVar(&to, "to", "zblock/miner1-log/", "folder of the log storage to create")
+	flag.Int64Var(&segmentSize, "segment-size", storage.DefaultSegmentSize, "size of the segment files in bytes")
+}
+
+func main() {
+	flag.Parse()
+
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	if entries, err := os.ReadDir(to); err == nil && len(entries) > 0 {
+		return fmt.Errorf("destination %s is not empty", to)
+	}
+
+	disk, err := storage.NewDiskStorage(from)
+	if err != nil {
+		return err
+	}
+	defer disk.Close()
+
+	ev := func(v string, args ...any) {
+		log.Printf(v, args...)
+	}
+
+	logStorage, err := storage.NewLogStorage(to, segmentSize, ev)
+	if err != nil {
+		return err
+	}
+
+	var migrated uint64
+	it := disk.Iterate(1, database.Latest)
+	for it.Next() {
+		block := it.Block()
+		if err := logStorage.Save(block); err != nil {
+			logStorage.Close()
+			return fmt.Errorf("saving block %d: %w", block.Header.Number, err)
+		}
+		migrated++
+	}
+	if err := it.Err(); err != nil {
+		logStorage.Close()
+		return fmt.Errorf("reading blocks: %w", err)
+	}
+
+	if err := logStorage.Close(); err != nil {
+		return err
+	}
+
+	// Read the blocks back to make sure they were written as they were read.
+	logStorage, err = storage.NewLogStorage(to, segmentSize, ev)
+	if err != nil {
+		return err
+	}
+	defer logStorage.Close()
+
+	it = disk.Iterate(1, database.Latest)
+	for it.Next() {
+		block := it.Block()
+		stored, err := logStorage.Find(block.Header.Number)
+		if err != nil {
+			return fmt.Errorf("verifying block %d: %w", block.Header.Number, err)
+		}
+		if stored.Hash() != block.Hash() || stored.MerkleTree.RootHex() != block.MerkleTree.RootHex() {
+			return fmt.Errorf("verifying block %d: block changed", block.Header.Number)
+		}
+	}
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("reading blocks: %w", err)
+	}
+
+	fmt.Printf("migrated %d blocks from %s to %s\n", migrated, from, to)
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/spf13/cobra"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+)
+
+var cancelCmd = &cobra.Command{
+	Use:   "cancel",
+	Short: "Cancel a pending transaction with a zero value transfer to yourself",
+	Run:   cancelRun,
+}
+
+func init() {
+	rootCmd.AddCommand(cancelCmd)
+	cancelCmd.Flags().StringVarP(&url, "url", "u", "http://localhost:8080", "Url of the node.")
+	cancelCmd.Flags().Uint64VarP(&nonce, "nonce", "n", 0, "Nonce of the pending transaction.")
+	cancelCmd.Flags().Uint64VarP(&tip, "tip", "c", 0, "New tip, defaults to the smallest accepted bump.")
+}
+
+func cancelRun(cmd *cobra.Command, args []string) {
+	privateKey, err := crypto.LoadECDSA(getPrivateKeyPath())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pending := findPending(privateKey, nonce)
+
+	tx, err := database.NewTx(pending.FromID, pending.FromID, 0, replacementTip(pending.Tip), pending.ChainID, nil, pending.Nonce)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	submit(tx, privateKey)
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/ecdsa"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 
@@ -67,6 +68,11 @@ func sendWithDetails(privateKey *ecdsa.PrivateKey) {
 		log.Fatal(err)
 	}
 
+	submit(tx, privateKey)
+}
+
+// submit signs the transaction and sends it to the node.
+func submit(tx database.Tx, privateKey *ecdsa.PrivateKey) {
 	signedTx, err := tx.Sign(privateKey)
 	if err != nil {
 		log.Fatal(err)
@@ -82,4 +88,9 @@ func sendWithDetails(privateKey *ecdsa.PrivateKey) {
 		log.Fatal(err)
 	}
 	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		log.Fatalf("transaction rejected: %s: %s", resp.Status, body)
+	}
 }
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/spf13/cobra"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+)
+
+var speedupCmd = &cobra.Command{
+	Use:   "speedup",
+	Short: "Replace a pending transaction with one paying a higher tip",
+	Run:   speedupRun,
+}
+
+func init() {
+	rootCmd.AddCommand(speedupCmd)
+	speedupCmd.Flags().StringVarP(&url, "url", "u", "http://localhost:8080", "Url of the node.")
+	speedupCmd.Flags().Uint64VarP(&nonce, "nonce", "n", 0, "Nonce of the pending transaction.")
+	speedupCmd.Flags().Uint64VarP(&tip, "tip", "c", 0, "New tip, defaults to the smallest accepted bump.")
+}
+
+func speedupRun(cmd *cobra.Command, args []string) {
+	privateKey, err := crypto.LoadECDSA(getPrivateKeyPath())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pending := findPending(privateKey, nonce)
+
+	tx, err := database.NewTx(pending.FromID, pending.ToID, pending.Value, replacementTip(pending.Tip), pending.ChainID, pending.Data, pending.Nonce)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	submit(tx, privateKey)
+}
+
+// =============================================================================
+
+// pendingTx is the part of the node's mempool response the wallet needs to
+// rebuild a transaction.
+type pendingTx struct {
+	FromID  database.AccountID `json:"from"`
+	ToID    database.AccountID `json:"to"`
+	ChainID uint16             `json:"chain_id"`
+	Nonce   uint64             `json:"nonce"`
+	Value   uint64             `json:"value"`
+	Tip     uint64             `json:"tip"`
+	Data    []byte             `json:"data"`
+}
+
+// findPending asks the node for the pending transaction signed by the key
+// with the specified nonce.
+func findPending(privateKey *ecdsa.PrivateKey, nonce uint64) pendingTx {
+	accountID, err := database.PublicKeyToAccountID(privateKey.PublicKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s/v1/tx/uncommitted/list/%s", url, accountID))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var txs []pendingTx
+	if err := json.NewDecoder(resp.Body).Decode(&txs); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, tx := range txs {
+		if tx.Nonce == nonce {
+			return tx
+		}
+	}
+
+	log.Fatalf("no pending transaction for account %s with nonce %d", accountID, nonce)
+	return pendingTx{}
+}
+
+// replacementTip returns the tip for a replacement transaction. The node
+// requires a 10% bump over the pending tip.
+func replacementTip(pendingTip uint64) uint64 {
+	minTip := uint64(math.Round(float64(pendingTip) * 1.10))
+	if minTip <= pendingTip {
+		minTip = pendingTip + 1
+	}
+
+	if tip > minTip {
+		return tip
+	}
+
+	return minTip
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/spf13/cobra"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+)
+
+var stakeCmd = &cobra.Command{
+	Use:   "stake",
+	Short: "Lock balance as stake to become a proof of stake validator",
+	Run:   stakeRun,
+}
+
+func init() {
+	rootCmd.AddCommand(stakeCmd)
+	stakeCmd.Flags().StringVarP(&url, "url", "u", "http://localhost:8080", "Url of the node.")
+	stakeCmd.Flags().Uint64VarP(&nonce, "nonce", "n", 0, "id for the transaction.")
+	stakeCmd.Flags().Uint64VarP(&value, "value", "v", 0, "Value to lock as stake.")
+	stakeCmd.Flags().Uint64VarP(&tip, "tip", "c", 0, "Tip to send.")
+}
+
+func stakeRun(cmd *cobra.Command, args []string) {
+	privateKey, err := crypto.LoadECDSA(getPrivateKeyPath())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fromAccount, err := database.PublicKeyToAccountID(privateKey.PublicKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	const chainID = 1
+	tx, err := database.NewTx(fromAccount, database.StakingAccountID, value, tip, chainID, nil, nonce)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	submit(tx, privateKey)
+}
@@ -18,10 +18,13 @@ var m *metrics
 // metrics represents the set of metrics we gather. These fields are
 // safe to be accessed concurrently thanks to expvar. No extra abstraction is required.
 type metrics struct {
-	goroutines *expvar.Int
-	requests   *expvar.Int
-	errors     *expvar.Int
-	panics     *expvar.Int
+	goroutines     *expvar.Int
+	requests       *expvar.Int
+	errors         *expvar.Int
+	panics         *expvar.Int
+	staleTemplates *expvar.Int
+	orphanedBlocks *expvar.Int
+	wastedHashes   *expvar.Int
 }
 
 // init constructs the metrics value that will be used to capture metrics.
@@ -34,6 +37,10 @@ func init() {
 		requests:   expvar.NewInt("requests"),
 		errors:     expvar.NewInt("errors"),
 		panics:     expvar.NewInt("panics"),
+
+		staleTemplates: expvar.NewInt("mining_stale_templates"),
+		orphanedBlocks: expvar.NewInt("mining_orphaned_blocks"),
+		wastedHashes:   expvar.NewInt("mining_wasted_hashes"),
 	}
 }
 
@@ -87,3 +94,28 @@ func AddPanics(ctx context.Context) {
 		v.panics.Add(1)
 	}
 }
+
+// =============================================================================
+
+// Mining collects the mining work that didn't end up in the chain. The worker
+// mines outside of any request, so it's handed this value instead of a
+// context.
+type Mining struct{}
+
+// AddStaleTemplates increments the mining operations abandoned because the
+// tip changed by 1.
+func (Mining) AddStaleTemplates() {
+	m.staleTemplates.Add(1)
+}
+
+// AddOrphanedBlocks increments the blocks solved after a competing block was
+// accepted by 1.
+func (Mining) AddOrphanedBlocks() {
+	m.orphanedBlocks.Add(1)
+}
+
+// AddWastedHashes adds the hashes performed for stale templates and orphaned
+// blocks.
+func (Mining) AddWastedHashes(hashes uint64) {
+	m.wastedHashes.Add(int64(hashes))
+}
@@ -0,0 +1,97 @@
+// Package consensus provides the rules nodes follow to produce blocks and to
+// agree on which blocks are valid.
+package consensus
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
+)
+
+// Set of consensus mechanisms that can be selected in the genesis file.
+const (
+	POW = "POW"
+	POA = "POA"
+	POS = "POS"
+	DEV = "DEV"
+)
+
+// maxClockDrift is how far ahead of the local clock a block can be dated.
+// The engines that decide who produces a block from its time stamp would
+// otherwise let a producer claim a turn it doesn't have yet by dating its
+// block in the future. It's kept below the one second resolution of the
+// block interval, so no producer can gain a whole interval.
+const maxClockDrift = 500 * time.Millisecond
+
+// StakesFunc returns the stake locked by every validator in the current
+// state of the chain.
+type StakesFunc func() map[database.AccountID]uint64
+
+// Engine represents the behavior required by a consensus mechanism.
+type Engine interface {
+
+	// Name returns the name of the consensus mechanism.
+	Name() string
+
+	// Interval returns how often the node should check if it can produce a
+	// block. Zero means blocks are produced when enough transactions are
+	// waiting in the mempool.
+	Interval() time.Duration
+
+	// CanSeal reports whether this node is allowed to produce the block that
+	// follows the specified block at the specified time.
+	CanSeal(prevBlock database.Block, now time.Time) bool
+
+	// Seal constructs the next block and performs whatever work is needed
+	// for the other nodes to accept it.
+	Seal(ctx context.Context, args database.POWArgs) (database.Block, error)
+
+	// Verify checks the block follows the rules of the consensus mechanism.
+	Verify(block database.Block, prevBlock database.Block) error
+}
+
+// Config represents the settings required to construct an engine.
+type Config struct {
+	Genesis    genesis.Genesis
+	PrivateKey *ecdsa.PrivateKey // Key of the node's beneficiary, used to sign blocks with POA and POS.
+	Stakes     StakesFunc
+	Now        func() time.Time // Clock the block time stamps are checked against. Nil uses the system clock.
+}
+
+// New constructs the engine for the consensus mechanism selected in the
+// genesis file. POW is used when none is specified.
+func New(cfg Config) (Engine, error) {
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+
+	switch strings.ToUpper(cfg.Genesis.Consensus) {
+	case "", POW:
+		return newPOW(cfg.Genesis), nil
+	case POA:
+		return newPOA(cfg.Genesis, cfg.PrivateKey, cfg.Now)
+	case POS:
+		return newPOS(cfg.Genesis, cfg.PrivateKey, cfg.Stakes, cfg.Now)
+	case DEV:
+		return newDev(), nil
+	}
+
+	return nil, errors.Errorf("unknown consensus mechanism %q", cfg.Genesis.Consensus)
+}
+
+// checkClock checks the time stamp in milliseconds isn't further ahead of
+// the clock than the allowed drift.
+func checkClock(timeStamp uint64, now time.Time) error {
+	limit := uint64(now.Add(maxClockDrift).UTC().UnixMilli())
+	if timeStamp > limit {
+		return errors.Errorf("Block is dated %dms ahead of this node's clock", timeStamp-uint64(now.UTC().UnixMilli()))
+	}
+
+	return nil
+}
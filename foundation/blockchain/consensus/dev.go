@@ -0,0 +1,55 @@
+package consensus
+
+import (
+	"context"
+	"time"
+
+	"emperror.dev/errors"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+)
+
+// dev implements instant sealing for local development. Blocks are sealed
+// as soon as a transaction is submitted, with a difficulty of 0 and no
+// nonce search. It provides no security and must never be used on a
+// network with other parties.
+type dev struct{}
+
+func newDev() *dev {
+	return &dev{}
+}
+
+// Name returns the name of the consensus mechanism.
+func (d *dev) Name() string {
+	return DEV
+}
+
+// Interval returns zero since blocks are sealed when transactions arrive.
+func (d *dev) Interval() time.Duration {
+	return 0
+}
+
+// CanSeal always returns true since there is no schedule to follow.
+func (d *dev) CanSeal(prevBlock database.Block, now time.Time) bool {
+	return true
+}
+
+// Seal constructs the next block with no work performed.
+func (d *dev) Seal(ctx context.Context, args database.POWArgs) (database.Block, error) {
+	args.Difficulty = 0
+
+	return database.NewBlock(args)
+}
+
+// Verify checks the block was sealed in development mode.
+func (d *dev) Verify(block database.Block, prevBlock database.Block) error {
+	if len(block.Header.Evidence) > 0 {
+		return errors.New("Evidence is only supported with POS")
+	}
+
+	if block.Header.Difficulty != 0 {
+		return errors.New("Development blocks must have a difficulty of 0")
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package consensus
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"time"
+
+	"emperror.dev/errors"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
+)
+
+// poa implements proof of authority. The signers listed in genesis take
+// turns producing blocks on a fixed interval, empty when there are no
+// transactions waiting. The signer whose turn it is
+// can produce a block once the interval has passed. In case that signer is
+// down, any other signer can step in after twice the interval, as long as
+// it didn't produce the previous block.
+type poa struct {
+	signers  []database.AccountID
+	interval time.Duration
+	key      *ecdsa.PrivateKey
+	self     database.AccountID
+	now      func() time.Time
+}
+
+func newPOA(g genesis.Genesis, key *ecdsa.PrivateKey, now func() time.Time) (*poa, error) {
+	if len(g.Signers) == 0 {
+		return nil, errors.New("POA requires at least one signer in genesis")
+	}
+
+	if g.BlockInterval == 0 {
+		return nil, errors.New("POA requires a block interval in genesis")
+	}
+
+	if key == nil {
+		return nil, errors.New("POA requires the node's private key")
+	}
+
+	signers := make([]database.AccountID, len(g.Signers))
+	for i, signer := range g.Signers {
+		accountID := database.AccountID(signer)
+		if !accountID.IsValid() {
+			return nil, errors.Errorf("invalid signer account %q", signer)
+		}
+		signers[i] = accountID
+	}
+
+	self, err := database.PublicKeyToAccountID(key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	p := poa{
+		signers:  signers,
+		interval: time.Duration(g.BlockInterval) * time.Second,
+		key:      key,
+		self:     self,
+		now:      now,
+	}
+
+	return &p, nil
+}
+
+// Name returns the name of the consensus mechanism.
+func (p *poa) Name() string {
+	return POA
+}
+
+// Interval returns how often to check whether it's this node's turn. The
+// check is cheap, so it's done every second to keep the interval accurate.
+func (p *poa) Interval() time.Duration {
+	return time.Second
+}
+
+// CanSeal reports whether this node is a signer that can produce the next
+// block at the specified time.
+func (p *poa) CanSeal(prevBlock database.Block, now time.Time) bool {
+	return p.eligible(p.self, prevBlock, uint64(now.UTC().UnixMilli())) == nil
+}
+
+// Seal constructs the next block and signs it with the node's key. There is
+// no work to perform, so the context is not used.
+func (p *poa) Seal(ctx context.Context, args database.POWArgs) (database.Block, error) {
+	args.BeneficiaryID = p.self
+	args.Difficulty = 0
+
+	block, err := database.NewBlock(args)
+	if err != nil {
+		return database.Block{}, err
+	}
+
+	if err := block.Sign(p.key); err != nil {
+		return database.Block{}, err
+	}
+
+	return block, nil
+}
+
+// Verify checks the block was signed by its beneficiary and that the signer
+// was allowed to produce it at the time stamped in the header. A block dated
+// ahead of the clock is rejected, since the time stamp decides whose turn
+// it is.
+func (p *poa) Verify(block database.Block, prevBlock database.Block) error {
+	if len(block.Header.Evidence) > 0 {
+		return errors.New("Evidence is only supported with POS")
+	}
+
+	signer, err := block.Signer()
+	if err != nil {
+		return err
+	}
+
+	if signer != block.Header.BeneficiaryID {
+		return errors.Errorf("Block signed by %s, not the beneficiary %s", signer, block.Header.BeneficiaryID)
+	}
+
+	if err := checkClock(block.Header.TimeStamp, p.now()); err != nil {
+		return err
+	}
+
+	return p.eligible(signer, prevBlock, block.Header.TimeStamp)
+}
+
+// eligible checks the signer can produce the block following the previous
+// block at the specified time in milliseconds.
+func (p *poa) eligible(signer database.AccountID, prevBlock database.Block, timeStamp uint64) error {
+	index := -1
+	for i, accountID := range p.signers {
+		if accountID == signer {
+			index = i
+			break
+		}
+	}
+
+	if index == -1 {
+		return errors.Errorf("Account %s is not an authorized signer", signer)
+	}
+
+	// The genesis block has no time stamp, so the first block can be
+	// produced right away by any signer.
+	if prevBlock.Header.Number == 0 {
+		return nil
+	}
+
+	wait := uint64(p.interval.Milliseconds())
+	inTurn := p.signers[(prevBlock.Header.Number+1)%uint64(len(p.signers))] == signer
+
+	if !inTurn {
+		wait *= 2
+
+		if len(p.signers) > 1 {
+			prevSigner, err := prevBlock.Signer()
+			if err != nil {
+				return errors.Wrap(err, "Previous block signer can't be recovered")
+			}
+			if prevSigner == signer {
+				return errors.Errorf("Signer %s can't produce two blocks in a row out of turn", signer)
+			}
+		}
+	}
+
+	if timeStamp < prevBlock.Header.TimeStamp+wait {
+		return errors.Errorf("Signer %s produced block %d too early", signer, prevBlock.Header.Number+1)
+	}
+
+	return nil
+}
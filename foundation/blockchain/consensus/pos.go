@@ -0,0 +1,218 @@
+package consensus
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+	"sort"
+	"time"
+
+	"emperror.dev/errors"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
+)
+
+// maxProducerPicks is the number of intervals the chain waits for one of the
+// picked producers before any validator is allowed to produce the block.
+const maxProducerPicks = 8
+
+// pos implements proof of stake. Accounts lock balance with a staking
+// transaction to become validators. For every block a producer is picked at
+// random in proportion to stake, seeded by the previous block's hash, so all
+// nodes agree on who it is. If the producer doesn't deliver within the
+// interval, another one is picked for every interval that goes by.
+//
+// A validator that signs two blocks at the same height is slashed once a
+// producer includes the evidence in a block.
+type pos struct {
+	interval time.Duration
+	key      *ecdsa.PrivateKey
+	self     database.AccountID
+	stakes   StakesFunc
+	now      func() time.Time
+}
+
+func newPOS(g genesis.Genesis, key *ecdsa.PrivateKey, stakes StakesFunc, now func() time.Time) (*pos, error) {
+	if g.BlockInterval == 0 {
+		return nil, errors.New("POS requires a block interval in genesis")
+	}
+
+	if key == nil {
+		return nil, errors.New("POS requires the node's private key")
+	}
+
+	if stakes == nil {
+		return nil, errors.New("POS requires access to the stakes")
+	}
+
+	self, err := database.PublicKeyToAccountID(key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	p := pos{
+		interval: time.Duration(g.BlockInterval) * time.Second,
+		key:      key,
+		self:     self,
+		stakes:   stakes,
+		now:      now,
+	}
+
+	return &p, nil
+}
+
+// Name returns the name of the consensus mechanism.
+func (p *pos) Name() string {
+	return POS
+}
+
+// Interval returns how often to check whether this node was picked. The
+// check is cheap, so it's done every second to keep the interval accurate.
+func (p *pos) Interval() time.Duration {
+	return time.Second
+}
+
+// CanSeal reports whether this node was picked to produce the next block at
+// the specified time.
+func (p *pos) CanSeal(prevBlock database.Block, now time.Time) bool {
+	return p.eligible(p.self, prevBlock, uint64(now.UTC().UnixMilli())) == nil
+}
+
+// Seal constructs the next block, including any pending evidence, and signs
+// it with the node's key. There is no work to perform, so the context is
+// not used.
+func (p *pos) Seal(ctx context.Context, args database.POWArgs) (database.Block, error) {
+	args.BeneficiaryID = p.self
+	args.Difficulty = 0
+
+	block, err := database.NewBlock(args)
+	if err != nil {
+		return database.Block{}, err
+	}
+
+	if err := block.Sign(p.key); err != nil {
+		return database.Block{}, err
+	}
+
+	return block, nil
+}
+
+// Verify checks the block was signed by a producer picked for the time
+// stamped in the header and that the evidence it carries is valid. A block
+// dated ahead of the clock is rejected, otherwise a validator could date it
+// past the last pick and produce it without being picked.
+func (p *pos) Verify(block database.Block, prevBlock database.Block) error {
+	signer, err := block.Signer()
+	if err != nil {
+		return err
+	}
+
+	if signer != block.Header.BeneficiaryID {
+		return errors.Errorf("Block signed by %s, not the beneficiary %s", signer, block.Header.BeneficiaryID)
+	}
+
+	if err := checkClock(block.Header.TimeStamp, p.now()); err != nil {
+		return err
+	}
+
+	if err := p.eligible(signer, prevBlock, block.Header.TimeStamp); err != nil {
+		return err
+	}
+
+	stakes := p.stakes()
+	slashed := make(map[database.AccountID]bool)
+
+	for _, eq := range block.Header.Evidence {
+		offender, err := eq.Offender()
+		if err != nil {
+			return errors.Wrap(err, "Invalid evidence")
+		}
+
+		if stakes[offender] == 0 || slashed[offender] {
+			return errors.Errorf("Evidence for %s who has no stake to slash", offender)
+		}
+		slashed[offender] = true
+	}
+
+	return nil
+}
+
+// eligible checks the signer was picked to produce the block following the
+// previous block at the specified time in milliseconds.
+func (p *pos) eligible(signer database.AccountID, prevBlock database.Block, timeStamp uint64) error {
+	stakes := p.stakes()
+
+	if stakes[signer] == 0 {
+		return errors.Errorf("Account %s has no stake", signer)
+	}
+
+	// The genesis block has no time stamp, so the first block can be
+	// produced right away by any validator.
+	if prevBlock.Header.Number == 0 {
+		return nil
+	}
+
+	wait := uint64(p.interval.Milliseconds())
+	if timeStamp < prevBlock.Header.TimeStamp+wait {
+		return errors.Errorf("Validator %s produced block %d too early", signer, prevBlock.Header.Number+1)
+	}
+
+	// One producer is picked for every interval that went by. Once enough
+	// of them failed to deliver, any validator can step in.
+	picks := (timeStamp - prevBlock.Header.TimeStamp) / wait
+	if picks > maxProducerPicks {
+		return nil
+	}
+
+	validators := sortedValidators(stakes)
+	for pick := uint64(0); pick < picks; pick++ {
+		if producer(validators, stakes, prevBlock.Hash(), pick) == signer {
+			return nil
+		}
+	}
+
+	return errors.Errorf("Validator %s was not picked to produce block %d", signer, prevBlock.Header.Number+1)
+}
+
+// sortedValidators returns the accounts with stake in a fixed order.
+func sortedValidators(stakes map[database.AccountID]uint64) []database.AccountID {
+	validators := make([]database.AccountID, 0, len(stakes))
+	for accountID := range stakes {
+		validators = append(validators, accountID)
+	}
+
+	sort.Slice(validators, func(i, j int) bool {
+		return validators[i] < validators[j]
+	})
+
+	return validators
+}
+
+// producer picks a validator in proportion to stake. The previous block's
+// hash and the pick number seed the choice so every node gets the same
+// answer.
+func producer(validators []database.AccountID, stakes map[database.AccountID]uint64, prevHash string, pick uint64) database.AccountID {
+	var total uint64
+	for _, accountID := range validators {
+		total += stakes[accountID]
+	}
+
+	data := make([]byte, len(prevHash)+8)
+	copy(data, prevHash)
+	binary.BigEndian.PutUint64(data[len(prevHash):], pick)
+	seed := sha256.Sum256(data)
+
+	target := new(big.Int).Mod(new(big.Int).SetBytes(seed[:]), new(big.Int).SetUint64(total)).Uint64()
+
+	for _, accountID := range validators {
+		if target < stakes[accountID] {
+			return accountID
+		}
+		target -= stakes[accountID]
+	}
+
+	return ""
+}
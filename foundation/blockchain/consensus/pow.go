@@ -0,0 +1,66 @@
+package consensus
+
+import (
+	"context"
+	"time"
+
+	"emperror.dev/errors"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
+)
+
+// pow implements proof of work. Any node can produce a block by solving the
+// hash puzzle for the difficulty set in genesis.
+type pow struct {
+	difficulty uint16
+}
+
+func newPOW(g genesis.Genesis) *pow {
+	return &pow{
+		difficulty: g.Difficulty,
+	}
+}
+
+// Name returns the name of the consensus mechanism.
+func (p *pow) Name() string {
+	return POW
+}
+
+// Interval returns zero since mining starts when the mempool has enough
+// transactions.
+func (p *pow) Interval() time.Duration {
+	return 0
+}
+
+// CanSeal always returns true since every node can mine.
+func (p *pow) CanSeal(prevBlock database.Block, now time.Time) bool {
+	return true
+}
+
+// Seal mines the next block.
+func (p *pow) Seal(ctx context.Context, args database.POWArgs) (database.Block, error) {
+	return database.POW(ctx, args)
+}
+
+// Verify checks the block's hash solves the puzzle at a difficulty that
+// isn't lower than the genesis or the previous block.
+func (p *pow) Verify(block database.Block, prevBlock database.Block) error {
+	if len(block.Header.Evidence) > 0 {
+		return errors.New("Evidence is only supported with POS")
+	}
+
+	if block.Header.Difficulty < p.difficulty {
+		return errors.New("Difficulty level can't be lower, than in genesis")
+	}
+
+	if block.Header.Difficulty < prevBlock.Header.Difficulty {
+		return errors.New("Difficulty level can't be lower, that in previous block")
+	}
+
+	if !database.IsHashSolved(block.Header.Difficulty, block.Hash()) {
+		return errors.New("Hash is not solved")
+	}
+
+	return nil
+}
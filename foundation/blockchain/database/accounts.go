@@ -64,6 +64,7 @@ type Account struct {
 	AccountID AccountID
 	Nonce     uint64
 	Balance   int64
+	Stake     uint64 `json:",omitempty"` // Balance locked by staking transactions for proof of stake.
 }
 
 // newAccount constructs a new account value for use.
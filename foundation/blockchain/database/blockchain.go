@@ -1,20 +1,36 @@
 package database
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
 	"crypto/rand"
+	"encoding/json"
+	"fmt"
 	"math"
 	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"emperror.dev/errors"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+
 	"github.com/ardanlabs/blockchain/foundation/blockchain/merkle"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/signature"
 )
 
+// BlockData is the encoding of a block for storage and the network. The
+// version is the BlockDataVersion it was written with, so older encodings
+// can be migrated when decoded. See encoding.go.
 type BlockData struct {
-	Hash   string      `json:hash`
-	Header BlockHeader `json:header`
-	Trans  []BlockTx   `json:tx`
+	Version uint16      `json:"version"`
+	Hash    string      `json:"hash"`
+	Pruned  bool        `json:"pruned,omitempty"` // The transactions were dropped by a pruned node. Only the header is left.
+	Header  BlockHeader `json:"header"`
+	Trans   []BlockTx   `json:"tx"`
 }
 
 type Block struct {
@@ -44,15 +60,32 @@ func (b Block) Hash() string {
 
 func NewBlockData(b Block) BlockData {
 	block := BlockData{
-		b.Hash(),
-		b.Header,
-		b.MerkleTree.Values(),
+		Version: BlockDataVersion,
+		Hash:    b.Hash(),
+		Header:  b.Header,
+		Trans:   b.MerkleTree.Values(),
 	}
 	return block
 }
 
-// ToBlock converts a storage block into a database block.
+// NewPrunedBlockData returns the encoding of a block without its
+// transactions, as kept by pruned nodes.
+func NewPrunedBlockData(header BlockHeader) BlockData {
+	return BlockData{
+		Version: BlockDataVersion,
+		Hash:    Block{Header: header}.Hash(),
+		Pruned:  true,
+		Header:  header,
+	}
+}
+
+// ToBlock converts a storage block into a database block. A pruned block
+// can't be converted since its transactions are gone.
 func ToBlock(blockData BlockData) (Block, error) {
+	if blockData.Pruned {
+		return Block{}, fmt.Errorf("block %d: %w", blockData.Header.Number, ErrPruned)
+	}
+
 	tree, err := merkle.NewTree(blockData.Trans)
 	if err != nil {
 		return Block{}, err
@@ -68,15 +101,64 @@ func ToBlock(blockData BlockData) (Block, error) {
 
 // BlockHeader represents common information required for each block.
 type BlockHeader struct {
-	Number        uint64    `json:"number"`          // Ethereum: Block number in the chain.
-	PrevBlockHash string    `json:"prev_block_hash"` // Bitcoin: Hash of the previous block in the chain.
-	TimeStamp     uint64    `json:"timestamp"`       // Bitcoin: Time the block was mined.
-	BeneficiaryID AccountID `json:"beneficiary"`     // Ethereum: The account who is receiving fees and tips.
-	Difficulty    uint16    `json:"difficulty"`      // Ethereum: Number of 0's needed to solve the hash solution.
-	MiningReward  uint64    `json:"mining_reward"`   // Ethereum: The reward for mining this block.
-	StateRoot     string    `json:"state_root"`      // Ethereum: Represents a hash of the accounts and their balances.
-	TransRoot     string    `json:"trans_root"`      // Both: Represents the merkle tree root hash for the transactions in this block.
-	Nonce         uint64    `json:"nonce"`           // Both: Value identified to solve the hash solution.
+	Number        uint64         `json:"number"`              // Ethereum: Block number in the chain.
+	PrevBlockHash string         `json:"prev_block_hash"`     // Bitcoin: Hash of the previous block in the chain.
+	TimeStamp     uint64         `json:"timestamp"`           // Bitcoin: Time the block was mined.
+	BeneficiaryID AccountID      `json:"beneficiary"`         // Ethereum: The account who is receiving fees and tips.
+	Difficulty    uint16         `json:"difficulty"`          // Ethereum: Number of 0's needed to solve the hash solution.
+	MiningReward  uint64         `json:"mining_reward"`       // Ethereum: The reward for mining this block.
+	StateRoot     string         `json:"state_root"`          // Ethereum: Represents a hash of the accounts and their balances.
+	TransRoot     string         `json:"trans_root"`          // Both: Represents the merkle tree root hash for the transactions in this block.
+	Nonce         uint64         `json:"nonce"`               // Both: Value identified to solve the hash solution.
+	Signature     string         `json:"signature,omitempty"` // Clique: Producer's signature of the header for proof of authority.
+	Evidence      []Equivocation `json:"evidence,omitempty"`  // Proof of stake: Producers to slash for signing two blocks at one height.
+}
+
+// Equivocation is the evidence that a producer signed two different blocks
+// at the same height.
+type Equivocation struct {
+	First  BlockHeader `json:"first"`
+	Second BlockHeader `json:"second"`
+}
+
+// Offender checks the two headers prove an equivocation and returns the
+// account that signed both of them.
+func (eq Equivocation) Offender() (AccountID, error) {
+	if eq.First.Number != eq.Second.Number {
+		return "", errors.New("Evidence headers are for different heights")
+	}
+
+	// The signature is left out, since the same signature can be encoded in
+	// more than one way and would make one block look like two.
+	firstHash, err := Block{Header: eq.First}.sealHash()
+	if err != nil {
+		return "", err
+	}
+
+	secondHash, err := Block{Header: eq.Second}.sealHash()
+	if err != nil {
+		return "", err
+	}
+
+	if bytes.Equal(firstHash, secondHash) {
+		return "", errors.New("Evidence headers are for the same block")
+	}
+
+	first, err := Block{Header: eq.First}.Signer()
+	if err != nil {
+		return "", errors.Wrap(err, "First evidence header")
+	}
+
+	second, err := Block{Header: eq.Second}.Signer()
+	if err != nil {
+		return "", errors.Wrap(err, "Second evidence header")
+	}
+
+	if first != second {
+		return "", errors.New("Evidence headers were signed by different producers")
+	}
+
+	return first, nil
 }
 
 // POWArgs represents the set of arguments required to run POW.
@@ -87,12 +169,39 @@ type POWArgs struct {
 	PrevBlock     Block
 	StateRoot     string
 	Trans         []BlockTx
+	Workers       int     // Number of goroutines searching for the nonce. Zero uses every CPU.
+	StartNonce    uint64  // Nonce the search starts from. Zero picks a random one.
+	TimeStamp     uint64  // Block time in milliseconds. Zero uses the current time.
+	Attempts      *uint64 // When set, counts the hashes performed. Updated atomically.
+	Evidence      []Equivocation
 	EvHandler     func(v string, args ...any)
 }
 
 // POW constructs a new Block and performs the work to find a nonce that
 // solves the cryptographic POW puzzel.
 func POW(ctx context.Context, args POWArgs) (Block, error) {
+	block, err := NewBlock(args)
+	if err != nil {
+		return Block{}, err
+	}
+
+	// Peform the proof of work mining operation.
+	attempts := args.Attempts
+	if attempts == nil {
+		attempts = new(uint64)
+	}
+
+	if err := block.performPOW(ctx, args.Workers, attempts, args.EvHandler); err != nil {
+		return Block{}, err
+	}
+
+	return block, nil
+}
+
+// NewBlock constructs the next block on top of the previous block from the
+// specified arguments. The block still needs to be sealed by the consensus
+// mechanism before it will be accepted.
+func NewBlock(args POWArgs) (Block, error) {
 
 	// When mining the first block, the previous block's hash will be zero.
 	prevBlockHash := signature.ZeroHash
@@ -107,33 +216,108 @@ func POW(ctx context.Context, args POWArgs) (Block, error) {
 		return Block{}, err
 	}
 
+	timeStamp := args.TimeStamp
+	if timeStamp == 0 {
+		timeStamp = uint64(time.Now().UTC().UnixMilli())
+	}
+
 	// Construct the block to be mined.
 	block := Block{
 		Header: BlockHeader{
 			Number:        args.PrevBlock.Header.Number + 1,
 			PrevBlockHash: prevBlockHash,
-			TimeStamp:     uint64(time.Now().UTC().UnixMilli()),
+			TimeStamp:     timeStamp,
 			BeneficiaryID: args.BeneficiaryID,
 			Difficulty:    args.Difficulty,
 			MiningReward:  args.MiningReward,
 			StateRoot:     args.StateRoot,
-			TransRoot:     tree.RootHex(), //
-			Nonce:         0,              // Will be identified by the POW algorithm.
+			TransRoot:     tree.RootHex(),  //
+			Nonce:         args.StartNonce, // Will be identified by the POW algorithm.
+			Evidence:      args.Evidence,
 		},
 		MerkleTree: tree,
 	}
 
-	// Peform the proof of work mining operation.
-	if err := block.performPOW(ctx, args.EvHandler); err != nil {
-		return Block{}, err
+	return block, nil
+}
+
+// =============================================================================
+
+// sealHash returns the hash the block producer signs. It covers the whole
+// header except for the signature itself.
+func (b Block) sealHash() ([]byte, error) {
+	header := b.Header
+	header.Signature = ""
+
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while marshalling header")
 	}
 
-	return block, nil
+	return crypto.Keccak256(data), nil
 }
 
+// Sign seals the block with the producer's signature. Pointer semantics are
+// being used since the signature is stored in the header.
+func (b *Block) Sign(key *ecdsa.PrivateKey) error {
+	hash, err := b.sealHash()
+	if err != nil {
+		return err
+	}
+
+	sig, err := crypto.Sign(hash, key)
+	if err != nil {
+		return errors.Wrap(err, "Error while signing block")
+	}
+
+	b.Header.Signature = hexutil.Encode(sig)
+
+	return nil
+}
+
+// Signer returns the account that signed the block.
+func (b Block) Signer() (AccountID, error) {
+	if b.Header.Signature == "" {
+		return "", errors.New("Block is not signed")
+	}
+
+	sig, err := hexutil.Decode(b.Header.Signature)
+	if err != nil {
+		return "", errors.Wrap(err, "Error while decoding signature")
+	}
+
+	// Only the low s form of a signature is accepted, so a signature can't
+	// be re-encoded into another valid one.
+	if len(sig) != crypto.SignatureLength || !crypto.ValidateSignatureValues(sig[64], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:64]), true) {
+		return "", errors.New("Invalid signature values")
+	}
+
+	hash, err := b.sealHash()
+	if err != nil {
+		return "", err
+	}
+
+	pub, err := crypto.SigToPub(hash, sig)
+	if err != nil {
+		return "", errors.Wrap(err, "Error while recovering signer")
+	}
+
+	return AccountID(crypto.PubkeyToAddress(*pub).String()), nil
+}
+
+// powReportInterval is how often the aggregate hash rate is reported while
+// mining is running.
+const powReportInterval = time.Second
+
 // performPOW does the work of mining to find a valid hash for a specified
 // block. Pointer semantics are being used since a nonce is being discovered.
-func (b *Block) performPOW(ctx context.Context, ev func(v string, args ...any)) error {
+//
+// The nonce space is split into equal, disjoint ranges, one per worker, that
+// begin at the block's nonce and wrap around. Every worker searches its own
+// range on a copy of the header, so there is no coordination between them
+// until one finds a solution and cancels the others. With a fixed starting
+// nonce and a single worker the search is fully deterministic.
+func (b *Block) performPOW(ctx context.Context, workers int, attempts *uint64, ev func(v string, args ...any)) error {
 	ev("database: PerformPOW: MINING: started")
 	defer ev("database: PerformPOW: MINING: completed")
 
@@ -142,42 +326,133 @@ func (b *Block) performPOW(ctx context.Context, ev func(v string, args ...any))
 		ev("database: PerformPOW: MINING: tx[%s]", tx)
 	}
 
-	// Choose a random starting point for the nonce. After this, the nonce
-	// will be incremented by 1 until a solution is found by us or another node.
-	nBig, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
-	if err != nil {
-		return ctx.Err()
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	// Choose a random starting point for the nonce when one isn't provided.
+	// After this, each worker increments its nonce by 1 until a solution is
+	// found by us or another node.
+	if b.Header.Nonce == 0 {
+		nBig, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
+		if err != nil {
+			return ctx.Err()
+		}
+		b.Header.Nonce = nBig.Uint64()
 	}
-	b.Header.Nonce = nBig.Uint64()
 
-	ev("viewer: PerformPOW: MINING: running")
+	ev("viewer: PerformPOW: MINING: running: workers[%d]", workers)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var count uint64
+	solved := make(chan BlockHeader, workers)
+	rangeSize := math.MaxUint64 / uint64(workers)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+
+	for i := 0; i < workers; i++ {
+		header := b.Header
+		header.Nonce += uint64(i) * rangeSize
+
+		go func() {
+			defer wg.Done()
+			if searchNonce(ctx, header, rangeSize, &count, solved) {
+				cancel()
+			}
+		}()
+	}
+
+	// Close the solved channel once every worker is done so the loop below
+	// knows when the search is over.
+	go func() {
+		wg.Wait()
+		close(solved)
+	}()
+
+	ticker := time.NewTicker(powReportInterval)
+	defer ticker.Stop()
 
 	// Loop until we or another node finds a solution for the next block.
-	var attempts uint64
 	for {
-		attempts++
-		if attempts%1_000_000 == 0 {
-			ev("viewer: PerformPOW: MINING: running: attempts[%d]", attempts)
+		select {
+		case header, ok := <-solved:
+			total := atomic.LoadUint64(&count)
+			atomic.AddUint64(attempts, total)
+
+			// Every worker stopped without a solution. Either we were
+			// cancelled or the whole nonce space was searched.
+			if !ok {
+				ev("database: PerformPOW: MINING: CANCELLED: attempts[%d]: rate[%d h/s]", total, hashRate(total, start))
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				return errors.New("no nonce solves the block")
+			}
+
+			b.Header = header
+			hash := b.Hash()
+
+			ev("database: PerformPOW: MINING: SOLVED: prevBlk[%s]: newBlk[%s]", b.Header.PrevBlockHash, hash)
+			ev("viewer: PerformPOW: MINING: attempts[%d]: rate[%d h/s]", total, hashRate(total, start))
+
+			return nil
+
+		case <-ticker.C:
+			total := atomic.LoadUint64(&count)
+			ev("viewer: PerformPOW: MINING: running: attempts[%d]: rate[%d h/s]", total, hashRate(total, start))
 		}
+	}
+}
 
-		// Did we timeout trying to solve the problem.
-		if ctx.Err() != nil {
-			ev("database: PerformPOW: MINING: CANCELLED")
-			return ctx.Err()
+// attemptsPerUpdate is the number of hashes a worker performs between
+// checking for cancellation and updating the shared attempts counter.
+const attemptsPerUpdate = 1_000
+
+// searchNonce looks for a solution in the specified range of nonces starting
+// with the header's nonce. The solved header is sent on the channel and true
+// is returned when a solution is found.
+func searchNonce(ctx context.Context, header BlockHeader, size uint64, attempts *uint64, solved chan<- BlockHeader) bool {
+	var local uint64
+	defer func() {
+		atomic.AddUint64(attempts, local)
+	}()
+
+	for i := uint64(0); i < size; i++ {
+		local++
+		if local == attemptsPerUpdate {
+			atomic.AddUint64(attempts, local)
+			local = 0
+
+			// Did we timeout or did another worker solve the problem.
+			if ctx.Err() != nil {
+				return false
+			}
 		}
 
-		// Hash the block and check if we have solved the puzzle.
-		hash := b.Hash()
-		if !IsHashSolved(b.Header.Difficulty, hash) {
-			b.Header.Nonce++
-			continue
+		// Hash the header and check if we have solved the puzzle.
+		if IsHashSolved(header.Difficulty, signature.Hash(header)) {
+			solved <- header
+			return true
 		}
 
-		ev("database: PerformPOW: MINING: SOLVED: prevBlk[%s]: newBlk[%s]", b.Header.PrevBlockHash, hash)
-		ev("database: PerformPOW: MINING: attempts[%d]", attempts)
+		header.Nonce++
+	}
+
+	return false
+}
 
-		return nil
+// hashRate calculates the number of hashes per second since start.
+func hashRate(attempts uint64, start time.Time) uint64 {
+	elapsed := time.Since(start).Seconds()
+	if elapsed == 0 {
+		return 0
 	}
+
+	return uint64(float64(attempts) / elapsed)
 }
 
 // IsHashSolved checks the hash to make sure it complies with
@@ -0,0 +1,141 @@
+package database_test
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+)
+
+func TestOffender(t *testing.T) {
+	key, err := crypto.HexToECDSA("fae85851bdf5c9f49923722ce38f3c1defcfd3619ef5453230a58ad805499959")
+	if err != nil {
+		t.Fatalf("loading key: %s", err)
+	}
+
+	signer, err := database.PublicKeyToAccountID(key.PublicKey)
+	if err != nil {
+		t.Fatalf("converting key: %s", err)
+	}
+
+	other, err := crypto.HexToECDSA("9f332e3700d8fc2446eaf6d15034cf96e0c2745e40353deef032a5dbf1dfed93")
+	if err != nil {
+		t.Fatalf("loading key: %s", err)
+	}
+
+	first := signHeader(t, key, 1)
+
+	tests := []struct {
+		name     string
+		second   database.BlockHeader
+		expected database.AccountID // Empty when the evidence must be rejected.
+	}{
+		{
+			name:     "two blocks",
+			second:   signHeader(t, key, 2),
+			expected: signer,
+		},
+		{
+			name:   "same block",
+			second: first,
+		},
+		{
+			name:   "malleated signature",
+			second: malleate(t, first),
+		},
+		{
+			name:   "different producers",
+			second: signHeader(t, other, 2),
+		},
+		{
+			name: "different heights",
+			second: func() database.BlockHeader {
+				h := signHeader(t, key, 2)
+				h.Number++
+				return h
+			}(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offender, err := database.Equivocation{First: first, Second: tt.second}.Offender()
+
+			switch {
+			case tt.expected == "" && err == nil:
+				t.Errorf("evidence accepted against %s", offender)
+			case tt.expected != "" && err != nil:
+				t.Errorf("evidence rejected: %s", err)
+			case offender != tt.expected:
+				t.Errorf("offender: got %s, expected %s", offender, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSignerRejectsMalleatedSignature(t *testing.T) {
+	key, err := crypto.HexToECDSA("fae85851bdf5c9f49923722ce38f3c1defcfd3619ef5453230a58ad805499959")
+	if err != nil {
+		t.Fatalf("loading key: %s", err)
+	}
+
+	header := signHeader(t, key, 1)
+
+	if _, err := (database.Block{Header: header}).Signer(); err != nil {
+		t.Fatalf("signer of the original signature: %s", err)
+	}
+
+	if signer, err := (database.Block{Header: malleate(t, header)}).Signer(); err == nil {
+		t.Errorf("malleated signature recovered %s", signer)
+	}
+}
+
+// =============================================================================
+
+// signHeader returns a header at height 10 signed by the key. The nonce tells
+// the blocks apart.
+func signHeader(t *testing.T, key *ecdsa.PrivateKey, nonce uint64) database.BlockHeader {
+	t.Helper()
+
+	block := database.Block{
+		Header: database.BlockHeader{
+			Number:        10,
+			PrevBlockHash: "0x0000d46890926364463eac42b73f20714130a9baca2223befdb2fd68dc64ef99",
+			TimeStamp:     1792401495855,
+			Nonce:         nonce,
+		},
+	}
+
+	if err := block.Sign(key); err != nil {
+		t.Fatalf("signing block: %s", err)
+	}
+
+	return block.Header
+}
+
+// malleate re-encodes the signature of the header into the other signature
+// that verifies for the same key: s becomes n - s and v is flipped.
+func malleate(t *testing.T, header database.BlockHeader) database.BlockHeader {
+	t.Helper()
+
+	sig, err := hexutil.Decode(header.Signature)
+	if err != nil {
+		t.Fatalf("decoding signature: %s", err)
+	}
+
+	s := new(big.Int).SetBytes(sig[32:64])
+	s.Sub(crypto.S256().Params().N, s)
+
+	malleated := make([]byte, len(sig))
+	copy(malleated, sig[:32])
+	s.FillBytes(malleated[32:64])
+	malleated[64] = sig[64] ^ 1
+
+	header.Signature = hexutil.Encode(malleated)
+
+	return header
+}
@@ -1,6 +1,9 @@
 package database
 
 import (
+	"fmt"
+	"math"
+	"sort"
 	"sync"
 
 	"emperror.dev/errors"
@@ -10,22 +13,70 @@ import (
 )
 
 type Database struct {
-	mx        sync.RWMutex
-	genesis   genesis.Genesis
-	accounts  map[AccountID]Account
-	evHandler func(v string, args ...interface{})
-	st        Storage
+	mx             sync.RWMutex
+	genesis        genesis.Genesis
+	latestBlock    Block
+	accounts       map[AccountID]Account
+	evHandler      func(v string, args ...interface{})
+	st             Storage
+	snapshotNumber uint64
 }
 
 type Storage interface {
 	Save(Block) error
 	Delete(blockNumber uint64) error
 	Find(blockNumber uint64) (Block, error)
-	List() ([]Block, error)
+	Iterate(from, to uint64) Iterator
+	Close() error
+}
+
+// Latest stands for the last stored block in the range of an iteration.
+const Latest uint64 = math.MaxUint64
+
+// Iterator walks over stored blocks one at a time, so the chain never has to
+// be in memory at once. Storage.Iterate walks from one block number to the
+// other, both included, and backward when from is greater than to. A to of
+// Latest walks forward to the last block and a from of Latest walks backward
+// from it.
+//
+//	it := st.Iterate(1, database.Latest)
+//	for it.Next() {
+//		block := it.Block()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+type Iterator interface {
+	Next() bool   // Moves to the next block. False when done or on error.
+	Block() Block // The block Next moved to.
+	Err() error   // The error that stopped the iteration, if any.
+}
+
+// Pruner is implemented by the storages that support pruned nodes. They
+// keep every header but drop the transactions of old blocks. The state those
+// blocks led to is kept as a snapshot, which the chain is replayed from.
+type Pruner interface {
+	Prune(before uint64, snapshot Snapshot) error
+	Snapshot() (Snapshot, bool, error)
+	FindHeader(blockNumber uint64) (BlockHeader, error)
+}
+
+// Snapshot is the state of the accounts after the block with the header.
+type Snapshot struct {
+	Header   BlockHeader `json:"header"`
+	Accounts []Account   `json:"accounts"`
 }
 
 var NotFound = errors.New("Account not found")
 
+// ErrPruned is returned when the transactions of a block were dropped by a
+// pruned node. The header of the block is still available.
+var ErrPruned = errors.New("block was pruned, only its header is kept")
+
+// ErrWrongNonce is returned when a transaction doesn't carry the nonce after
+// the last one its account used. Unlike a transaction the account can't pay
+// for, it makes the whole block invalid.
+var ErrWrongNonce = errors.New("wrong nonce")
+
 func NewDatabase(genesis genesis.Genesis, st Storage, ev func(v string, args ...interface{})) (*Database, error) {
 	db := Database{
 		evHandler: ev,
@@ -44,18 +95,64 @@ func NewDatabase(genesis genesis.Genesis, st Storage, ev func(v string, args ...
 		ev("Account : %s, Balance : %d", accountIString, balances)
 	}
 
-	list, err := st.List()
-	if err != nil {
-		return nil, errors.Wrap(err, "Error while listing blocks")
+	for accountIString, stake := range genesis.Stakes {
+		accountId, err := ToAccountID(accountIString)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error while converting accountID")
+		}
+
+		account := db.accounts[accountId]
+		account.AccountID = accountId
+		account.Stake = stake
+		db.accounts[accountId] = account
+		ev("Account : %s, Stake : %d", accountIString, stake)
 	}
 
-	for _, block := range list {
-		for _, tx := range block.MerkleTree.Values() {
-			if err := db.ApplyTransaction(tx, block.Header.BeneficiaryID); err != nil {
-				return nil, errors.Wrap(err, "Error while applying transaction")
+	// A pruned chain is replayed from the latest snapshot, since the blocks
+	// before it may have no transactions left.
+	from := uint64(1)
+	if pruner, ok := st.(Pruner); ok {
+		snapshot, exists, err := pruner.Snapshot()
+		if err != nil {
+			return nil, errors.Wrap(err, "Error while reading snapshot")
+		}
+
+		if exists {
+			block, err := st.Find(snapshot.Header.Number)
+			if err != nil {
+				return nil, errors.Wrap(err, "Error while finding snapshot block")
 			}
+			if block.Hash() != (Block{Header: snapshot.Header}).Hash() {
+				return nil, errors.Errorf("Snapshot doesn't match block %d", snapshot.Header.Number)
+			}
+
+			db.accounts = make(map[AccountID]Account, len(snapshot.Accounts))
+			for _, account := range snapshot.Accounts {
+				db.accounts[account.AccountID] = account
+			}
+			db.latestBlock = block
+			db.snapshotNumber = block.Header.Number
+			from = block.Header.Number + 1
+
+			ev("Snapshot : block %d, accounts %d", block.Header.Number, len(snapshot.Accounts))
+		}
+	}
+
+	// The blocks are replayed one at a time, so the memory used at boot
+	// doesn't grow with the chain. They are applied the same way ApplyBlock
+	// applied them when they were added, mining reward included, so the
+	// replay ends on the state the chain was built with.
+	it := st.Iterate(from, Latest)
+	for it.Next() {
+		block := it.Block()
+		if err := db.applyBlock(block); err != nil {
+			return nil, errors.Wrapf(err, "Error while applying block %d", block.Header.Number)
 		}
+		db.latestBlock = block
+	}
 
+	if err := it.Err(); err != nil {
+		return nil, errors.Wrap(err, "Error while reading blocks")
 	}
 
 	return &db, nil
@@ -94,39 +191,241 @@ func (db *Database) Query(id AccountID) (Account, error) {
 
 }
 
+// GetStateRoot returns a hash of all the accounts. The accounts are sorted
+// so every node with the same state produces the same hash.
 func (db *Database) GetStateRoot() string {
-	return signature.Hash(db.All())
+	accounts := db.All()
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].AccountID < accounts[j].AccountID
+	})
+
+	return signature.Hash(accounts)
+}
+
+// ApplyBlock applies the block to a copy of the accounts and writes it to
+// storage as the new latest block. The accounts are only replaced once the
+// block is stored, so a failed write leaves the state as it was.
+func (db *Database) ApplyBlock(block Block) error {
+	db.mx.RLock()
+	next := Database{
+		genesis:   db.genesis,
+		accounts:  make(map[AccountID]Account, len(db.accounts)),
+		evHandler: db.evHandler,
+	}
+	for id, account := range db.accounts {
+		next.accounts[id] = account
+	}
+	db.mx.RUnlock()
+
+	if err := next.applyBlock(block); err != nil {
+		return err
+	}
+
+	if err := db.st.Save(block); err != nil {
+		return err
+	}
+
+	db.mx.Lock()
+	defer db.mx.Unlock()
+
+	db.accounts = next.accounts
+	db.latestBlock = block
+
+	return nil
 }
 
-func (db *Database) Save(block Block) error {
-	return db.st.Save(block)
+// applyBlock applies the transactions, the evidence and the mining reward of
+// the block. A transaction the account can't pay for fails and the block is
+// still applied. A transaction with the wrong nonce fails the block, which is
+// left partially applied, so the caller must discard the accounts.
+func (db *Database) applyBlock(block Block) error {
+	for _, tx := range block.MerkleTree.Values() {
+		if err := db.ApplyTransaction(tx, block.Header.BeneficiaryID); err != nil {
+			if errors.Is(err, ErrWrongNonce) {
+				return errors.Wrapf(err, "Invalid transaction %s", tx)
+			}
+			db.evHandler("database: applyBlock: blk[%d]: tx[%s]: FAILED: %s", block.Header.Number, tx, err)
+		}
+	}
+
+	db.ApplyEvidence(block.Header.Evidence)
+	db.ApplyMiningReward(block.Header.BeneficiaryID)
+
+	return nil
 }
 
-func (db *Database) ApplyTransaction(tx BlockTx, beneficiaryID AccountID) error {
-	from := db.accounts[tx.FromID]
-	to := db.accounts[tx.ToID]
-	beneficiary := db.accounts[beneficiaryID]
+// Close flushes and releases the storage. No blocks can be saved after.
+func (db *Database) Close() error {
+	return db.st.Close()
+}
+
+// LatestBlock returns the latest block added to the chain. Before the first
+// block is mined this is the zero value, which represents genesis.
+func (db *Database) LatestBlock() Block {
+	db.mx.RLock()
+	defer db.mx.RUnlock()
+
+	return db.latestBlock
+}
+
+// GetBlock returns the block with the specified number from storage.
+func (db *Database) GetBlock(number uint64) (Block, error) {
+	return db.st.Find(number)
+}
+
+// GetHeader returns the header of the block with the specified number. The
+// header is available even when the block was pruned.
+func (db *Database) GetHeader(number uint64) (BlockHeader, error) {
+	if pruner, ok := db.st.(Pruner); ok {
+		return pruner.FindHeader(number)
+	}
 
-	if uint64(from.Balance) < tx.Value+tx.Tip+tx.GasUnits*tx.GasPrice {
-		from.Balance -= int64(tx.GasUnits * tx.GasPrice)
+	block, err := db.st.Find(number)
+	if err != nil {
+		return BlockHeader{}, err
+	}
+
+	return block.Header, nil
+}
 
-		return errors.New("Not enough balance. However we've charged extra money for gas.")
+// Prune snapshots the accounts and drops the transactions of the blocks that
+// are more than depth blocks behind the latest one. It only does the work once
+// every depth blocks, so between depth and twice depth blocks keep their
+// transactions. The caller must make sure no block is applied meanwhile.
+func (db *Database) Prune(depth uint64) error {
+	pruner, ok := db.st.(Pruner)
+	if !ok {
+		return errors.New("Storage doesn't support pruning")
 	}
 
-	from.Balance -= int64(tx.Value + tx.Tip + tx.GasUnits*tx.GasPrice)
-	to.Balance += int64(tx.Value)
+	latest := db.LatestBlock()
+	if depth == 0 || latest.Header.Number <= depth || latest.Header.Number < db.snapshotNumber+depth {
+		return nil
+	}
+
+	snapshot := Snapshot{
+		Header:   latest.Header,
+		Accounts: db.All(),
+	}
+	sort.Slice(snapshot.Accounts, func(i, j int) bool {
+		return snapshot.Accounts[i].AccountID < snapshot.Accounts[j].AccountID
+	})
 
-	beneficiary.Balance += int64(tx.Tip)
-	beneficiary.Balance += int64(tx.GasUnits * tx.GasPrice)
+	before := latest.Header.Number - depth + 1
+	if err := pruner.Prune(before, snapshot); err != nil {
+		return err
+	}
+	db.snapshotNumber = latest.Header.Number
 
+	db.evHandler("viewer: database: Prune: blocks before %d pruned: snapshot at blk[%d]", before, latest.Header.Number)
+
+	return nil
+}
+
+// Blocks returns an iterator over the stored blocks between the specified
+// numbers. See Iterator for how the range is walked.
+func (db *Database) Blocks(from, to uint64) Iterator {
+	return db.st.Iterate(from, to)
+}
+
+// ApplyTransaction moves the value, the tip and the gas fee of the
+// transaction. The transaction must carry the nonce after the last one its
+// account used, or ErrWrongNonce is returned and nothing changes. A
+// transaction the account can't pay for in full fails and nothing is
+// charged, but its nonce is used so it can't be included again.
+func (db *Database) ApplyTransaction(tx BlockTx, beneficiaryID AccountID) error {
+	db.mx.Lock()
+	defer db.mx.Unlock()
+
+	from, exists := db.accounts[tx.FromID]
+	if !exists {
+		from = newAccount(tx.FromID, 0)
+	}
+
+	if tx.Nonce != from.Nonce+1 {
+		return fmt.Errorf("%w: got %d, expected %d", ErrWrongNonce, tx.Nonce, from.Nonce+1)
+	}
+
+	from.Nonce = tx.Nonce
 	db.accounts[tx.FromID] = from
-	db.accounts[tx.ToID] = to
-	db.accounts[beneficiaryID] = beneficiary
+
+	gasFee := tx.GasUnits * tx.GasPrice
+	if uint64(from.Balance) < tx.Value+tx.Tip+gasFee {
+		return errors.New("Not enough balance")
+	}
+
+	db.adjustBalance(tx.FromID, -int64(tx.Value+tx.Tip+gasFee))
+	db.adjustBalance(beneficiaryID, int64(tx.Tip+gasFee))
+
+	// A staking transaction moves the value from the sender's balance into
+	// the sender's stake.
+	if tx.IsStake() {
+		from = db.accounts[tx.FromID]
+		from.Stake += tx.Value
+		db.accounts[tx.FromID] = from
+		return nil
+	}
+
+	db.adjustBalance(tx.ToID, int64(tx.Value))
 
 	return nil
 }
 
+// Stakes returns the stake locked by every account that has one.
+func (db *Database) Stakes() map[AccountID]uint64 {
+	db.mx.RLock()
+	defer db.mx.RUnlock()
+
+	stakes := make(map[AccountID]uint64)
+	for id, account := range db.accounts {
+		if account.Stake > 0 {
+			stakes[id] = account.Stake
+		}
+	}
+
+	return stakes
+}
+
+// ApplyEvidence slashes the stake of the producers that signed two blocks at
+// the same height. The slashed stake is burned. Evidence that doesn't prove
+// an equivocation is ignored since the block was validated beforehand.
+func (db *Database) ApplyEvidence(evidence []Equivocation) {
+	db.mx.Lock()
+	defer db.mx.Unlock()
+
+	for _, eq := range evidence {
+		offender, err := eq.Offender()
+		if err != nil {
+			continue
+		}
+
+		account, exists := db.accounts[offender]
+		if !exists || account.Stake == 0 {
+			continue
+		}
+
+		db.evHandler("viewer: database: ApplyEvidence: SLASHED: account[%s]: stake[%d]: blk[%d]", offender, account.Stake, eq.First.Number)
+
+		account.Stake = 0
+		db.accounts[offender] = account
+	}
+}
+
 func (db *Database) ApplyMiningReward(beneficiaryID AccountID) {
-	beneficiary := db.accounts[beneficiaryID]
-	beneficiary.Balance += db.genesis.MiningReward
+	db.mx.Lock()
+	defer db.mx.Unlock()
+
+	db.adjustBalance(beneficiaryID, db.genesis.MiningReward)
+}
+
+// adjustBalance adds delta to the balance of the specified account, creating
+// the account if it doesn't exist. The caller must hold the write lock.
+func (db *Database) adjustBalance(id AccountID, delta int64) {
+	account, exists := db.accounts[id]
+	if !exists {
+		account = newAccount(id, 0)
+	}
+
+	account.Balance += delta
+	db.accounts[id] = account
 }
@@ -0,0 +1,359 @@
+package database_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+)
+
+const (
+	to          = database.AccountID("0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76")
+	beneficiary = database.AccountID("0xFef311483Cc040e1A89fb9bb469eeB8A70935EF8")
+)
+
+func TestApplyTransaction(t *testing.T) {
+	key := loadKey(t)
+
+	tests := []struct {
+		name         string
+		nonce        uint64
+		value        uint64
+		wrong        bool   // The nonce must be rejected.
+		nonceAfter   uint64 // Nonce of the account after the transaction.
+		balanceAfter int64  // Balance of the account after the transaction.
+		failed       bool   // The transaction must fail for its balance.
+	}{
+		{name: "next nonce", nonce: 1, value: 10, nonceAfter: 1, balanceAfter: 1_000 - 10 - 1 - 15},
+		{name: "zero nonce", nonce: 0, value: 10, wrong: true, balanceAfter: 1_000},
+		{name: "gap", nonce: 2, value: 10, wrong: true, balanceAfter: 1_000},
+		{name: "not enough balance", nonce: 1, value: 1_000, failed: true, nonceAfter: 1, balanceAfter: 1_000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newDatabase(t, key, storage.NewMemoryStorage())
+
+			err := db.ApplyTransaction(newTx(t, key, tt.nonce, tt.value), beneficiary)
+
+			switch {
+			case tt.wrong && !errors.Is(err, database.ErrWrongNonce):
+				t.Errorf("expected a wrong nonce, got %v", err)
+			case tt.failed && (err == nil || errors.Is(err, database.ErrWrongNonce)):
+				t.Errorf("expected a balance failure, got %v", err)
+			case !tt.wrong && !tt.failed && err != nil:
+				t.Errorf("transaction failed: %s", err)
+			}
+
+			account := query(t, db, key)
+			if account.Nonce != tt.nonceAfter {
+				t.Errorf("nonce: got %d, expected %d", account.Nonce, tt.nonceAfter)
+			}
+			if account.Balance != tt.balanceAfter {
+				t.Errorf("balance: got %d, expected %d", account.Balance, tt.balanceAfter)
+			}
+		})
+	}
+}
+
+func TestReplay(t *testing.T) {
+	key := loadKey(t)
+
+	tests := []struct {
+		name   string
+		nonces []uint64 // Nonces of the transactions of the second block.
+		values []uint64
+		valid  bool
+	}{
+		{name: "next nonce", nonces: []uint64{2}, values: []uint64{10}, valid: true},
+		{name: "not enough balance", nonces: []uint64{2, 3}, values: []uint64{10_000, 10}, valid: true},
+		{name: "replayed transaction", nonces: []uint64{1}, values: []uint64{10}},
+		{name: "gap", nonces: []uint64{3}, values: []uint64{10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := storage.NewMemoryStorage()
+
+			first := mineBlock(t, database.Block{}, newTx(t, key, 1, 10))
+			if err := st.Save(first); err != nil {
+				t.Fatalf("saving block: %s", err)
+			}
+
+			var trans []database.BlockTx
+			for i, nonce := range tt.nonces {
+				trans = append(trans, newTx(t, key, nonce, tt.values[i]))
+			}
+			if err := st.Save(mineBlock(t, first, trans...)); err != nil {
+				t.Fatalf("saving block: %s", err)
+			}
+
+			db, err := database.NewDatabase(newGenesis(t, key), st, func(v string, args ...any) {})
+
+			switch {
+			case !tt.valid:
+				if !errors.Is(err, database.ErrWrongNonce) {
+					t.Errorf("expected a wrong nonce, got %v", err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("replaying blocks: %s", err)
+			}
+
+			// The sender pays only for the transactions it could afford, and
+			// uses the nonce of every transaction.
+			expected := int64(1_000)
+			for _, tx := range append([]database.BlockTx{first.MerkleTree.Values()[0]}, trans...) {
+				if tx.Value <= 1_000 {
+					expected -= int64(tx.Value + tx.Tip + tx.GasPrice*tx.GasUnits)
+				}
+			}
+
+			account := query(t, db, key)
+			if last := tt.nonces[len(tt.nonces)-1]; account.Nonce != last {
+				t.Errorf("nonce: got %d, expected %d", account.Nonce, last)
+			}
+			if account.Balance != expected {
+				t.Errorf("balance: got %d, expected %d", account.Balance, expected)
+			}
+		})
+	}
+}
+
+func TestApplyBlockRejectsWrongNonce(t *testing.T) {
+	key := loadKey(t)
+	db := newDatabase(t, key, storage.NewMemoryStorage())
+
+	block := mineBlock(t, database.Block{}, newTx(t, key, 1, 10), newTx(t, key, 3, 10))
+
+	if err := db.ApplyBlock(block); !errors.Is(err, database.ErrWrongNonce) {
+		t.Fatalf("expected a wrong nonce, got %v", err)
+	}
+
+	// The transaction before the wrong nonce must not be left applied.
+	account := query(t, db, key)
+	if account.Nonce != 0 || account.Balance != 1_000 {
+		t.Errorf("account changed: nonce %d, balance %d", account.Nonce, account.Balance)
+	}
+	if latest := db.LatestBlock().Header.Number; latest != 0 {
+		t.Errorf("latest block: got %d, expected 0", latest)
+	}
+}
+
+func TestNewDatabaseFromSnapshot(t *testing.T) {
+	key := loadKey(t)
+	dir := t.TempDir()
+
+	st, err := storage.NewLogStorage(dir, 1, nil)
+	if err != nil {
+		t.Fatalf("opening storage: %s", err)
+	}
+
+	db := newDatabase(t, key, st)
+
+	var prev database.Block
+	for nonce := uint64(1); nonce <= 5; nonce++ {
+		block := mineBlock(t, prev, newTx(t, key, nonce, 10))
+		if err := db.ApplyBlock(block); err != nil {
+			t.Fatalf("applying block %d: %s", nonce, err)
+		}
+		if err := db.Prune(2); err != nil {
+			t.Fatalf("pruning: %s", err)
+		}
+		prev = block
+	}
+
+	stateRoot := db.GetStateRoot()
+	account := query(t, db, key)
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("closing database: %s", err)
+	}
+
+	// The genesis funds no account, so the balances can only come from the
+	// snapshot, and the pruned blocks can't be replayed.
+	gen := newGenesis(t, key)
+	gen.Balances = nil
+
+	st, err = storage.NewLogStorage(dir, 1, nil)
+	if err != nil {
+		t.Fatalf("opening storage: %s", err)
+	}
+
+	db, err = database.NewDatabase(gen, st, func(v string, args ...any) {})
+	if err != nil {
+		t.Fatalf("reopening database: %s", err)
+	}
+	defer db.Close()
+
+	if _, err := db.GetBlock(1); !errors.Is(err, database.ErrPruned) {
+		t.Errorf("block 1: expected a pruned block, got %v", err)
+	}
+
+	if latest := db.LatestBlock(); latest.Hash() != prev.Hash() {
+		t.Errorf("latest block: got %d, expected %d", latest.Header.Number, prev.Header.Number)
+	}
+	if root := db.GetStateRoot(); root != stateRoot {
+		t.Errorf("state root: got %s, expected %s", root, stateRoot)
+	}
+	if got := query(t, db, key); got != account {
+		t.Errorf("account: got %+v, expected %+v", got, account)
+	}
+}
+
+func TestNewDatabaseSnapshotMismatch(t *testing.T) {
+	key := loadKey(t)
+	dir := t.TempDir()
+
+	st, err := storage.NewLogStorage(dir, 1, nil)
+	if err != nil {
+		t.Fatalf("opening storage: %s", err)
+	}
+
+	db := newDatabase(t, key, st)
+
+	var prev database.Block
+	for nonce := uint64(1); nonce <= 3; nonce++ {
+		prev = mineBlock(t, prev, newTx(t, key, nonce, 10))
+		if err := db.ApplyBlock(prev); err != nil {
+			t.Fatalf("applying block %d: %s", nonce, err)
+		}
+	}
+
+	// The snapshot claims to be for the latest block, but its header isn't
+	// the one that was stored.
+	header := prev.Header
+	header.Nonce++
+	if err := st.Prune(2, database.Snapshot{Header: header, Accounts: db.All()}); err != nil {
+		t.Fatalf("pruning: %s", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("closing database: %s", err)
+	}
+
+	st, err = storage.NewLogStorage(dir, 1, nil)
+	if err != nil {
+		t.Fatalf("opening storage: %s", err)
+	}
+	defer st.Close()
+
+	if _, err := database.NewDatabase(newGenesis(t, key), st, func(v string, args ...any) {}); err == nil || !strings.Contains(err.Error(), "Snapshot doesn't match") {
+		t.Fatalf("expected a snapshot mismatch, got %v", err)
+	}
+}
+
+// =============================================================================
+
+// loadKey returns the key of the funded account.
+func loadKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := crypto.HexToECDSA("fae85851bdf5c9f49923722ce38f3c1defcfd3619ef5453230a58ad805499959")
+	if err != nil {
+		t.Fatalf("loading key: %s", err)
+	}
+
+	return key
+}
+
+// newGenesis returns a genesis that funds the account of the key.
+func newGenesis(t *testing.T, key *ecdsa.PrivateKey) genesis.Genesis {
+	t.Helper()
+
+	from, err := database.PublicKeyToAccountID(key.PublicKey)
+	if err != nil {
+		t.Fatalf("converting key: %s", err)
+	}
+
+	return genesis.Genesis{
+		Date:          time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC),
+		ChainID:       1,
+		TransPerBlock: 10,
+		Difficulty:    1,
+		MiningReward:  700,
+		GasPrice:      15,
+		Balances:      map[string]int64{string(from): 1_000},
+	}
+}
+
+// newDatabase constructs a database over the storage with the account of the
+// key funded.
+func newDatabase(t *testing.T, key *ecdsa.PrivateKey, st database.Storage) *database.Database {
+	t.Helper()
+
+	db, err := database.NewDatabase(newGenesis(t, key), st, func(v string, args ...any) {})
+	if err != nil {
+		t.Fatalf("constructing database: %s", err)
+	}
+
+	return db
+}
+
+// query returns the account of the key.
+func query(t *testing.T, db *database.Database, key *ecdsa.PrivateKey) database.Account {
+	t.Helper()
+
+	from, err := database.PublicKeyToAccountID(key.PublicKey)
+	if err != nil {
+		t.Fatalf("converting key: %s", err)
+	}
+
+	account, err := db.Query(from)
+	if err != nil {
+		t.Fatalf("querying account: %s", err)
+	}
+
+	return account
+}
+
+// newTx signs a transfer from the key with the specified nonce.
+func newTx(t *testing.T, key *ecdsa.PrivateKey, nonce uint64, value uint64) database.BlockTx {
+	t.Helper()
+
+	from, err := database.PublicKeyToAccountID(key.PublicKey)
+	if err != nil {
+		t.Fatalf("converting key: %s", err)
+	}
+
+	tx, err := database.NewTx(from, to, value, 1, 1, nil, nonce)
+	if err != nil {
+		t.Fatalf("constructing transaction: %s", err)
+	}
+
+	signedTx, err := tx.Sign(key)
+	if err != nil {
+		t.Fatalf("signing transaction: %s", err)
+	}
+
+	return database.NewBlockTx(signedTx, 15, 1)
+}
+
+// mineBlock mines the block after the previous one with the transactions.
+func mineBlock(t *testing.T, prev database.Block, trans ...database.BlockTx) database.Block {
+	t.Helper()
+
+	block, err := database.POW(context.Background(), database.POWArgs{
+		BeneficiaryID: beneficiary,
+		Difficulty:    1,
+		MiningReward:  700,
+		PrevBlock:     prev,
+		Trans:         trans,
+		Workers:       1,
+		TimeStamp:     prev.Header.TimeStamp + 1,
+		EvHandler:     func(v string, args ...any) {},
+	})
+	if err != nil {
+		t.Fatalf("mining block: %s", err)
+	}
+
+	return block
+}
@@ -0,0 +1,437 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+
+	"emperror.dev/errors"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// BlockDataVersion is the version of the block encoding written by this node.
+// Bump it and register a migration when the header or transaction fields
+// change, so blocks already on disk or sent by older peers still decode.
+//
+//	1: The BlockData JSON without a version field. Nodes exchanged blocks
+//	   this way and DiskStorage has written it since syncing from peers was
+//	   added. Before that DiskStorage encoded the Block type itself, which
+//	   fails since the merkle tree refuses to be marshaled, so no older
+//	   files exist.
+//	2: Adds the version field and the binary encoding.
+//	3: Adds the pruned flag.
+const BlockDataVersion = 3
+
+// migrations upgrade the JSON fields of a block from the version they are
+// registered under to the next version. The fields are kept raw, so a
+// migration can rename, reshape or default them without depending on the
+// current Go types.
+var migrations = map[uint16]func(fields map[string]json.RawMessage) error{
+	1: func(fields map[string]json.RawMessage) error {
+		return nil // The fields are the same, version 2 only adds the version field.
+	},
+	2: func(fields map[string]json.RawMessage) error {
+		return nil // Blocks without the pruned flag weren't pruned.
+	},
+}
+
+// blockData has the fields of BlockData without its methods, so they can be
+// decoded with the standard behavior once migrated.
+type blockData BlockData
+
+// UnmarshalJSON decodes a block of any known version, migrating it to the
+// current BlockDataVersion.
+func (bd *BlockData) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	version := uint16(1)
+	if raw, exists := fields["version"]; exists {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return errors.Wrap(err, "Error while decoding block version")
+		}
+	}
+
+	if version > BlockDataVersion {
+		return fmt.Errorf("block version %d is newer than the supported version %d", version, BlockDataVersion)
+	}
+
+	if version < BlockDataVersion {
+		for ; version < BlockDataVersion; version++ {
+			migrate, exists := migrations[version]
+			if !exists {
+				return fmt.Errorf("no migration for block version %d", version)
+			}
+			if err := migrate(fields); err != nil {
+				return errors.Wrapf(err, "Error while migrating block version %d", version)
+			}
+		}
+
+		fields["version"] = json.RawMessage(fmt.Sprint(BlockDataVersion))
+
+		migrated, err := json.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		data = migrated
+	}
+
+	return json.Unmarshal(data, (*blockData)(bd))
+}
+
+// =============================================================================
+
+// binaryMagic starts every block in the binary encoding.
+var binaryMagic = []byte("BLK")
+
+// binaryReaders decode the binary layout of every version that had one. When
+// the layout changes the reader of the old version is kept here.
+var binaryReaders = map[uint16]func(r *binaryReader, bd *BlockData){
+	2: readBlockDataV2,
+	3: readBlockDataV3,
+}
+
+// MarshalBinary encodes the block in a compact binary form. Numbers are
+// varints and hex strings are stored as raw bytes.
+func (bd BlockData) MarshalBinary() ([]byte, error) {
+	w := binaryWriter{}
+
+	w.buf.Write(binaryMagic)
+	w.uint(BlockDataVersion)
+	w.string(bd.Hash)
+	w.bool(bd.Pruned)
+	w.header(bd.Header)
+
+	w.uint(uint64(len(bd.Trans)))
+	for _, tx := range bd.Trans {
+		w.tx(tx)
+	}
+
+	if w.err != nil {
+		return nil, w.err
+	}
+
+	return w.buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a block in the binary form of any version that had
+// one.
+func (bd *BlockData) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, binaryMagic) {
+		return errors.New("Not a binary encoded block")
+	}
+
+	r := binaryReader{r: bytes.NewReader(data[len(binaryMagic):])}
+
+	version := uint16(r.uint())
+	if r.err != nil {
+		return errors.Wrap(r.err, "Error while decoding block version")
+	}
+
+	read, exists := binaryReaders[version]
+	if !exists {
+		return fmt.Errorf("unsupported binary block version %d", version)
+	}
+
+	var decoded BlockData
+	read(&r, &decoded)
+	if r.err != nil {
+		return errors.Wrapf(r.err, "Error while decoding block version %d", version)
+	}
+	if r.r.Len() != 0 {
+		return fmt.Errorf("%d unexpected bytes after block", r.r.Len())
+	}
+
+	decoded.Version = BlockDataVersion
+	*bd = decoded
+
+	return nil
+}
+
+// readBlockDataV2 reads the layout written by MarshalBinary in version 2.
+func readBlockDataV2(r *binaryReader, bd *BlockData) {
+	bd.Hash = r.string()
+	bd.Header = r.header()
+	bd.Trans = r.trans()
+}
+
+// readBlockDataV3 reads the layout written by MarshalBinary since version 3,
+// which adds the pruned flag after the hash.
+func readBlockDataV3(r *binaryReader, bd *BlockData) {
+	bd.Hash = r.string()
+	bd.Pruned = r.bool()
+	bd.Header = r.header()
+	bd.Trans = r.trans()
+}
+
+// =============================================================================
+
+// String encodings in the binary form.
+const (
+	stringRaw byte = iota // Length prefixed bytes of the string.
+	stringHex             // Length prefixed bytes of a canonical 0x hex string.
+)
+
+// binaryWriter writes the binary form. The first error is kept and every
+// write after it is skipped.
+type binaryWriter struct {
+	buf bytes.Buffer
+	err error
+}
+
+func (w *binaryWriter) uint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (w *binaryWriter) bytes(b []byte) {
+	w.uint(uint64(len(b)))
+	w.buf.Write(b)
+}
+
+// string stores hex strings as their bytes when decoding them back gives the
+// same string. Anything else, like checksummed accounts, is stored as is.
+func (w *binaryWriter) string(s string) {
+	if b, err := hexutil.Decode(s); err == nil && len(b) > 0 && hexutil.Encode(b) == s {
+		w.buf.WriteByte(stringHex)
+		w.bytes(b)
+		return
+	}
+
+	w.buf.WriteByte(stringRaw)
+	w.bytes([]byte(s))
+}
+
+func (w *binaryWriter) bool(v bool) {
+	if v {
+		w.buf.WriteByte(1)
+		return
+	}
+
+	w.buf.WriteByte(0)
+}
+
+// optionalBytes keeps the difference between nil and empty, which changes
+// the JSON a transaction is signed over.
+func (w *binaryWriter) optionalBytes(b []byte) {
+	if b == nil {
+		w.buf.WriteByte(0)
+		return
+	}
+
+	w.buf.WriteByte(1)
+	w.bytes(b)
+}
+
+func (w *binaryWriter) bigInt(v *big.Int) {
+	switch {
+	case v == nil:
+		w.optionalBytes(nil)
+	case v.Sign() < 0:
+		if w.err == nil {
+			w.err = errors.New("Negative signature values can't be encoded")
+		}
+	default:
+		w.optionalBytes(v.Bytes())
+	}
+}
+
+func (w *binaryWriter) header(h BlockHeader) {
+	w.uint(h.Number)
+	w.string(h.PrevBlockHash)
+	w.uint(h.TimeStamp)
+	w.string(string(h.BeneficiaryID))
+	w.uint(uint64(h.Difficulty))
+	w.uint(h.MiningReward)
+	w.string(h.StateRoot)
+	w.string(h.TransRoot)
+	w.uint(h.Nonce)
+	w.string(h.Signature)
+
+	w.uint(uint64(len(h.Evidence)))
+	for _, eq := range h.Evidence {
+		w.header(eq.First)
+		w.header(eq.Second)
+	}
+}
+
+func (w *binaryWriter) tx(tx BlockTx) {
+	w.string(string(tx.FromID))
+	w.string(string(tx.ToID))
+	w.uint(tx.Value)
+	w.uint(tx.Tip)
+	w.uint(uint64(tx.ChainId))
+	w.optionalBytes(tx.Data)
+	w.uint(tx.Nonce)
+	w.bigInt(tx.V)
+	w.bigInt(tx.R)
+	w.bigInt(tx.S)
+	w.uint(tx.TimeStamp)
+	w.uint(tx.GasPrice)
+	w.uint(tx.GasUnits)
+}
+
+// binaryReader reads the binary form. The first error is kept and every read
+// after it returns zero values.
+type binaryReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (r *binaryReader) uint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+
+	v, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		r.err = err
+		return 0
+	}
+
+	return v
+}
+
+// count reads a length and checks there are at least that many bytes left,
+// so a corrupted length can't cause a huge allocation.
+func (r *binaryReader) count() int {
+	n := r.uint()
+	if r.err == nil && n > uint64(r.r.Len()) {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+
+	return int(n)
+}
+
+func (r *binaryReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+
+	b, err := r.r.ReadByte()
+	if err != nil {
+		r.err = err
+		return 0
+	}
+
+	return b
+}
+
+func (r *binaryReader) bytes() []byte {
+	n := r.count()
+	if r.err != nil {
+		return nil
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		r.err = err
+		return nil
+	}
+
+	return b
+}
+
+func (r *binaryReader) string() string {
+	switch kind := r.byte(); {
+	case r.err != nil:
+		return ""
+	case kind == stringHex:
+		return hexutil.Encode(r.bytes())
+	case kind == stringRaw:
+		return string(r.bytes())
+	default:
+		r.err = fmt.Errorf("unknown string encoding %d", kind)
+		return ""
+	}
+}
+
+func (r *binaryReader) bool() bool {
+	return r.byte() == 1
+}
+
+func (r *binaryReader) optionalBytes() []byte {
+	if r.byte() == 0 {
+		return nil
+	}
+
+	return r.bytes()
+}
+
+func (r *binaryReader) bigInt() *big.Int {
+	b := r.optionalBytes()
+	if b == nil {
+		return nil
+	}
+
+	return new(big.Int).SetBytes(b)
+}
+
+func (r *binaryReader) header() BlockHeader {
+	h := BlockHeader{
+		Number:        r.uint(),
+		PrevBlockHash: r.string(),
+		TimeStamp:     r.uint(),
+		BeneficiaryID: AccountID(r.string()),
+		Difficulty:    uint16(r.uint()),
+		MiningReward:  r.uint(),
+		StateRoot:     r.string(),
+		TransRoot:     r.string(),
+		Nonce:         r.uint(),
+		Signature:     r.string(),
+	}
+
+	if n := r.count(); n > 0 {
+		h.Evidence = make([]Equivocation, n)
+		for i := range h.Evidence {
+			h.Evidence[i].First = r.header()
+			h.Evidence[i].Second = r.header()
+		}
+	}
+
+	return h
+}
+
+func (r *binaryReader) trans() []BlockTx {
+	n := r.count()
+	if n == 0 {
+		return nil
+	}
+
+	trans := make([]BlockTx, n)
+	for i := range trans {
+		trans[i] = r.tx()
+	}
+
+	return trans
+}
+
+func (r *binaryReader) tx() BlockTx {
+	var tx BlockTx
+
+	tx.FromID = AccountID(r.string())
+	tx.ToID = AccountID(r.string())
+	tx.Value = r.uint()
+	tx.Tip = r.uint()
+	tx.ChainId = uint16(r.uint())
+	tx.Data = r.optionalBytes()
+	tx.Nonce = r.uint()
+	tx.V = r.bigInt()
+	tx.R = r.bigInt()
+	tx.S = r.bigInt()
+	tx.TimeStamp = r.uint()
+	tx.GasPrice = r.uint()
+	tx.GasUnits = r.uint()
+
+	return tx
+}
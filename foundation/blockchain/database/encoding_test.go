@@ -0,0 +1,304 @@
+package database_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+)
+
+// blockV1 is a block as it was written before the encoding was versioned.
+const blockV1 = `{
+	"hash": "0x0000d46890926364463eac42b73f20714130a9baca2223befdb2fd68dc64ef99",
+	"header": {
+		"number": 1,
+		"prev_block_hash": "0x0000000000000000000000000000000000000000000000000000000000000000",
+		"timestamp": 1792401495855,
+		"beneficiary": "0xFef311483Cc040e1A89fb9bb469eeB8A70935EF8",
+		"difficulty": 4,
+		"mining_reward": 700,
+		"state_root": "0x187c4fd4c30c3ae694644dda31978228a9e6326f82384105093e11cb5a0d28a9",
+		"trans_root": "0x31e6b04b7301df72c2f4ab0b4428d5a56c1ed7cb180ef1c457f40b0b4bfb7413",
+		"nonce": 6646290816935633540
+	},
+	"tx": [
+		{
+			"from_id": "0xF01813E4B85e178A83e29B8E7bF26BD830a25f32",
+			"to_id": "0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76",
+			"value": 10,
+			"tip": 1,
+			"chain_id": 1,
+			"data": null,
+			"nonce": 1,
+			"V": 29,
+			"R": 84829108360247581057823162954145326267197376656343660074251124551654036342152,
+			"S": 2162018207341720365761502728165510734895638794566770702944234189836638205442,
+			"timestamp": 1792401495840,
+			"gas_price": 15,
+			"gas_units": 1
+		}
+	]
+}`
+
+func TestBlockDataV1(t *testing.T) {
+	var bd database.BlockData
+	if err := json.Unmarshal([]byte(blockV1), &bd); err != nil {
+		t.Fatalf("decoding version 1 block: %s", err)
+	}
+
+	if bd.Version != database.BlockDataVersion {
+		t.Errorf("version: got %d, expected %d", bd.Version, database.BlockDataVersion)
+	}
+
+	if bd.Pruned {
+		t.Error("version 1 block decoded as pruned")
+	}
+
+	block, err := database.ToBlock(bd)
+	if err != nil {
+		t.Fatalf("converting version 1 block: %s", err)
+	}
+
+	if hash := block.Hash(); hash != bd.Hash {
+		t.Errorf("hash: got %s, expected %s", hash, bd.Hash)
+	}
+
+	if root := block.MerkleTree.RootHex(); root != bd.Header.TransRoot {
+		t.Errorf("trans root: got %s, expected %s", root, bd.Header.TransRoot)
+	}
+
+	if err := bd.Trans[0].IsValid(); err != nil {
+		t.Errorf("transaction signature: %s", err)
+	}
+
+	// The migrated block must survive both encodings unchanged.
+	checkJSONRoundTrip(t, bd)
+	checkBinaryRoundTrip(t, bd)
+}
+
+func TestBlockDataV2(t *testing.T) {
+	bd := newBlockData(t)
+
+	data, err := json.Marshal(bd)
+	if err != nil {
+		t.Fatalf("encoding block: %s", err)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("decoding fields: %s", err)
+	}
+	fields["version"] = json.RawMessage("2")
+	delete(fields, "pruned")
+
+	v2, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatalf("encoding version 2 block: %s", err)
+	}
+
+	var got database.BlockData
+	if err := json.Unmarshal(v2, &got); err != nil {
+		t.Fatalf("decoding version 2 block: %s", err)
+	}
+
+	if got.Version != database.BlockDataVersion {
+		t.Errorf("version: got %d, expected %d", got.Version, database.BlockDataVersion)
+	}
+	checkEqual(t, got, bd)
+
+	// Version 2 of the binary form has no pruned flag after the hash. The
+	// hash is canonical hex, so it takes a kind byte, a length byte and 32
+	// bytes after the magic and the version.
+	bin, err := bd.MarshalBinary()
+	if err != nil {
+		t.Fatalf("encoding binary block: %s", err)
+	}
+
+	const magic, hash = 3, 1 + 1 + 32
+	binV2 := append([]byte{}, bin[:magic]...)
+	binV2 = append(binV2, 2)
+	binV2 = append(binV2, bin[magic+1:magic+1+hash]...)
+	binV2 = append(binV2, bin[magic+1+hash+1:]...)
+
+	got = database.BlockData{}
+	if err := got.UnmarshalBinary(binV2); err != nil {
+		t.Fatalf("decoding version 2 binary block: %s", err)
+	}
+	checkEqual(t, got, bd)
+}
+
+func TestBlockDataFutureVersion(t *testing.T) {
+	data := []byte(`{"version": 999, "hash": "0x00", "header": {}, "tx": null}`)
+
+	var bd database.BlockData
+	if err := json.Unmarshal(data, &bd); err == nil {
+		t.Error("block with a future version was decoded")
+	}
+}
+
+func TestBlockDataRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(bd *database.BlockData)
+	}{
+		{
+			name:   "signed",
+			modify: func(bd *database.BlockData) {},
+		},
+		{
+			name: "non canonical hex",
+			modify: func(bd *database.BlockData) {
+				bd.Header.BeneficiaryID = "0xfef311483cc040e1a89fb9bb469eeb8a70935ef8"
+				bd.Header.PrevBlockHash = "0X00ABCDEF"
+				bd.Header.StateRoot = "0x1"
+				bd.Header.Signature = "0x"
+				bd.Trans[0].ToID = "0xBEE6ACE826EC3DE1B6349888B9151B92522F7F76"
+			},
+		},
+		{
+			name: "data",
+			modify: func(bd *database.BlockData) {
+				bd.Trans[0].Data = []byte{}
+				bd.Trans[1].Data = []byte("payload")
+			},
+		},
+		{
+			name: "evidence",
+			modify: func(bd *database.BlockData) {
+				bd.Header.Evidence = []database.Equivocation{
+					{First: bd.Header, Second: bd.Header},
+				}
+			},
+		},
+		{
+			name: "pruned",
+			modify: func(bd *database.BlockData) {
+				*bd = database.NewPrunedBlockData(bd.Header)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bd := newBlockData(t)
+			tt.modify(&bd)
+
+			checkJSONRoundTrip(t, bd)
+			checkBinaryRoundTrip(t, bd)
+		})
+	}
+}
+
+// =============================================================================
+
+// newBlockData constructs a block with two signed transactions.
+func newBlockData(t *testing.T) database.BlockData {
+	t.Helper()
+
+	key, err := crypto.HexToECDSA("fae85851bdf5c9f49923722ce38f3c1defcfd3619ef5453230a58ad805499959")
+	if err != nil {
+		t.Fatalf("loading key: %s", err)
+	}
+
+	from, err := database.PublicKeyToAccountID(key.PublicKey)
+	if err != nil {
+		t.Fatalf("converting key: %s", err)
+	}
+
+	var trans []database.BlockTx
+	for nonce := uint64(1); nonce <= 2; nonce++ {
+		tx, err := database.NewTx(from, "0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76", 10*nonce, 1, 1, nil, nonce)
+		if err != nil {
+			t.Fatalf("constructing transaction: %s", err)
+		}
+
+		signedTx, err := tx.Sign(key)
+		if err != nil {
+			t.Fatalf("signing transaction: %s", err)
+		}
+
+		trans = append(trans, database.NewBlockTx(signedTx, 15, 1))
+	}
+
+	header := database.BlockHeader{
+		Number:        7,
+		PrevBlockHash: "0x0000d46890926364463eac42b73f20714130a9baca2223befdb2fd68dc64ef99",
+		TimeStamp:     1792401495855,
+		BeneficiaryID: from,
+		Difficulty:    4,
+		MiningReward:  700,
+		StateRoot:     "0x187c4fd4c30c3ae694644dda31978228a9e6326f82384105093e11cb5a0d28a9",
+		TransRoot:     "0x31e6b04b7301df72c2f4ab0b4428d5a56c1ed7cb180ef1c457f40b0b4bfb7413",
+		Nonce:         6646290816935633540,
+	}
+
+	return database.BlockData{
+		Version: database.BlockDataVersion,
+		Hash:    database.Block{Header: header}.Hash(),
+		Header:  header,
+		Trans:   trans,
+	}
+}
+
+// checkJSONRoundTrip encodes the block to JSON and back.
+func checkJSONRoundTrip(t *testing.T, bd database.BlockData) {
+	t.Helper()
+
+	data, err := json.Marshal(bd)
+	if err != nil {
+		t.Fatalf("encoding JSON: %s", err)
+	}
+
+	var got database.BlockData
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("decoding JSON: %s", err)
+	}
+
+	checkEqual(t, got, bd)
+}
+
+// checkBinaryRoundTrip encodes the block to the binary form and back.
+func checkBinaryRoundTrip(t *testing.T, bd database.BlockData) {
+	t.Helper()
+
+	data, err := bd.MarshalBinary()
+	if err != nil {
+		t.Fatalf("encoding binary: %s", err)
+	}
+
+	var got database.BlockData
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatalf("decoding binary: %s", err)
+	}
+
+	checkEqual(t, got, bd)
+}
+
+// checkEqual compares the blocks by their JSON, which is what the hashes and
+// signatures are computed over.
+func checkEqual(t *testing.T, got database.BlockData, expected database.BlockData) {
+	t.Helper()
+
+	gotJSON, err := json.Marshal(got)
+	if err != nil {
+		t.Fatalf("encoding result: %s", err)
+	}
+
+	expectedJSON, err := json.Marshal(expected)
+	if err != nil {
+		t.Fatalf("encoding expected: %s", err)
+	}
+
+	if !bytes.Equal(gotJSON, expectedJSON) {
+		t.Errorf("block changed\ngot:      %s\nexpected: %s", gotJSON, expectedJSON)
+	}
+
+	for i, tx := range got.Trans {
+		if tx.IsValid() != nil && expected.Trans[i].IsValid() == nil {
+			t.Errorf("tx[%d]: signature no longer valid", i)
+		}
+	}
+}
@@ -43,6 +43,22 @@ func NewTx(fromID AccountID, toID AccountID, value uint64, tip uint64, chainId u
 		Nonce:   nonce}, nil
 }
 
+// IsCancel reports whether the transaction is a zero value transfer to the
+// sender, used to replace and cancel a pending transaction with the same nonce.
+func (tx Tx) IsCancel() bool {
+	return tx.FromID == tx.ToID && tx.Value == 0
+}
+
+// StakingAccountID is the account staking transactions are sent to. The
+// value of a staking transaction is locked as the sender's stake instead of
+// being credited to this account.
+const StakingAccountID AccountID = "0x0000000000000000000000000000000000000001"
+
+// IsStake reports whether the transaction locks balance as stake.
+func (tx Tx) IsStake() bool {
+	return tx.ToID == StakingAccountID
+}
+
 func stamp(tx Tx) ([]byte, error) {
 	marshal, err := json.Marshal(tx)
 	if err != nil {
@@ -100,12 +116,16 @@ func (tx SignedTx) IsValid() error {
 		return errors.New("Invalid toID account")
 	}
 
-	if tx.Value == 0 {
-		return errors.New("Value must be greater than 0")
-	}
+	// A zero value transfer to yourself is how a pending transaction gets
+	// cancelled, since it takes the nonce without moving any money.
+	if !tx.IsCancel() {
+		if tx.Value == 0 {
+			return errors.New("Value must be greater than 0")
+		}
 
-	if tx.FromID == tx.ToID {
-		return errors.New("FromID and ToID must be different")
+		if tx.FromID == tx.ToID {
+			return errors.New("FromID and ToID must be different")
+		}
 	}
 
 	if !signature.ValidateSignatureValues(tx.V, tx.R, tx.S) {
@@ -113,11 +133,16 @@ func (tx SignedTx) IsValid() error {
 	}
 
 	address, err := tx.fromSignToAddress()
-
-	if !(address == tx.FromID) || err != nil {
+	if err != nil {
 		return errors.Wrap(err, "Invalid signature")
 	}
 
+	// Wrap returns nil for a nil error, so a signature from another account
+	// needs its own error.
+	if address != tx.FromID {
+		return errors.New("Invalid signature: signed by another account")
+	}
+
 	return nil
 }
 
@@ -137,6 +162,13 @@ func (tx SignedTx) fromSignToAddress() (AccountID, error) {
 	return AccountID(crypto.PubkeyToAddress(*pub).String()), nil
 }
 
+// TxHash returns the canonical hash of the signed transaction. It doesn't
+// include the values a node adds when the transaction is recorded in a block,
+// so every node computes the same hash for the same transaction.
+func (tx SignedTx) TxHash() string {
+	return signature.Hash(tx)
+}
+
 // SignatureString returns the signature as a string.
 func (tx SignedTx) SignatureString() string {
 	return signature.SignatureString(tx.V, tx.R, tx.S)
@@ -154,6 +186,18 @@ type BlockTx struct {
 	TimeStamp uint64 `json:"timestamp"` // Ethereum: The time the transaction was received.
 	GasPrice  uint64 `json:"gas_price"` // Ethereum: The price of one unit of gas to be paid for fees.
 	GasUnits  uint64 `json:"gas_units"` // Ethereum: The number of units of gas used for this transaction.
+	Size      uint64 `json:"-"`         // Bytes the transaction takes encoded. Set by the mempool, zero when unknown.
+}
+
+// EncodedSize returns the number of bytes the transaction takes when
+// encoded.
+func (tx BlockTx) EncodedSize() uint64 {
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return 0
+	}
+
+	return uint64(len(data))
 }
 
 // NewBlockTx creates a new BlockTx value.
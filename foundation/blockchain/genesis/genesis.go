@@ -4,16 +4,28 @@ import (
 	"encoding/json"
 	"os"
 	"time"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/signature"
 )
 
 type Genesis struct {
-	Date          time.Time        `json:"date"`
-	ChainID       uint16           `json:"chain_id"`
-	TransPerBlock uint16           `json:"trans_per_block"`
-	Difficulty    uint16           `json:"difficulty"`
-	MiningReward  int64            `json:"mining_reward"`
-	GasPrice      uint64           `json:"gas_price"`
-	Balances      map[string]int64 `json:"balances"`
+	Date          time.Time         `json:"date"`
+	ChainID       uint16            `json:"chain_id"`
+	TransPerBlock uint16            `json:"trans_per_block"`
+	Difficulty    uint16            `json:"difficulty"`
+	MiningReward  int64             `json:"mining_reward"`
+	GasPrice      uint64            `json:"gas_price"`
+	Balances      map[string]int64  `json:"balances"`
+	Consensus     string            `json:"consensus,omitempty"`      // POW (the default), POA, POS or DEV.
+	Signers       []string          `json:"signers,omitempty"`        // POA: Accounts allowed to produce blocks, in turn order.
+	BlockInterval uint64            `json:"block_interval,omitempty"` // POA and POS: Seconds between blocks.
+	Stakes        map[string]uint64 `json:"stakes,omitempty"`         // POS: Stake each validator starts with.
+}
+
+// Hash returns a unique hash for the genesis settings. Nodes with different
+// genesis hashes are on different chains.
+func (g Genesis) Hash() string {
+	return signature.Hash(g)
 }
 
 func Load() (Genesis, error) {
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"sort"
 	"strings"
 	"sync"
 
@@ -11,6 +12,10 @@ import (
 	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool/selector"
 )
 
+// ErrReplaceUnderpriced is returned when a transaction tries to replace a
+// pending one without paying a big enough tip.
+var ErrReplaceUnderpriced = errors.New("replacing a transaction requires a 10% bump in the tip")
+
 type MemPool struct {
 	mw       sync.RWMutex
 	pool     map[string]database.BlockTx
@@ -22,7 +27,14 @@ func NewMemPool() (*MemPool, error) {
 }
 
 func NewWithStrategy(strategy string) (*MemPool, error) {
-	selectFn, err := selector.Retrieve(strategy)
+	return NewWithLimits(strategy, selector.Limits{})
+}
+
+// NewWithLimits constructs a mempool that selects the transactions for
+// blocks with the specified gas and size limits. Only the strategies that
+// consider the block limits apply them.
+func NewWithLimits(strategy string, limits selector.Limits) (*MemPool, error) {
+	selectFn, err := selector.RetrieveWithLimits(strategy, limits)
 	if err != nil {
 		return nil, err
 	}
@@ -69,12 +81,16 @@ func (mp *MemPool) Upsert(tx database.BlockTx) error {
 
 	key := mapKey(tx)
 
+	// The size is computed once here instead of every time the strategy
+	// weighs the transaction.
+	tx.Size = tx.EncodedSize()
+
 	// Ethereum requires a 10% bump in the tip to replace an existing
 	// transaction in the mempool and so do we. We want to limit users
 	// from this sort of behavior.
 	if etx, exists := mp.pool[key]; exists {
 		if tx.Tip < uint64(math.Round(float64(etx.Tip)*1.10)) {
-			return errors.New("replacing a transaction requires a 10% bump in the tip")
+			return ErrReplaceUnderpriced
 		}
 	}
 
@@ -83,6 +99,50 @@ func (mp *MemPool) Upsert(tx database.BlockTx) error {
 	return nil
 }
 
+// Find returns the pending transaction for the specified account and nonce.
+func (mp *MemPool) Find(accountID database.AccountID, nonce uint64) (database.BlockTx, bool) {
+	mp.mw.RLock()
+	defer mp.mw.RUnlock()
+
+	tx, exists := mp.pool[nonceKey(accountID, nonce)]
+	return tx, exists
+}
+
+// ForAccount returns the pending transactions for the specified account
+// ordered by nonce.
+func (mp *MemPool) ForAccount(accountID database.AccountID) []database.BlockTx {
+	mp.mw.RLock()
+	defer mp.mw.RUnlock()
+
+	var txs []database.BlockTx
+	for key, tx := range mp.pool {
+		if accountFromMapKey(key) == accountID {
+			txs = append(txs, tx)
+		}
+	}
+
+	sort.Slice(txs, func(i, j int) bool {
+		return txs[i].Nonce < txs[j].Nonce
+	})
+
+	return txs
+}
+
+// FindByHash returns the pending transaction with the specified canonical
+// transaction hash.
+func (mp *MemPool) FindByHash(hash string) (database.BlockTx, bool) {
+	mp.mw.RLock()
+	defer mp.mw.RUnlock()
+
+	for _, tx := range mp.pool {
+		if strings.EqualFold(tx.TxHash(), hash) {
+			return tx, true
+		}
+	}
+
+	return database.BlockTx{}, false
+}
+
 // PickBest uses the configured sort strategy to return a set of transactions.
 // If 0 is passed, all transactions in the mempool will be returned.
 func (mp *MemPool) PickBest(howMany ...uint16) []database.BlockTx {
@@ -103,14 +163,12 @@ func (mp *MemPool) PickBest(howMany ...uint16) []database.BlockTx {
 	// selected as the only form of revenue. This will change how transactions
 	// need to be selected.
 
-	// Copy all the transactions for each account into separate slices.
+	// Copy all the transactions for each account into separate slices. The
+	// strategy receives 0 as is, since it's the only way to ask for every
+	// transaction without the block limits being applied.
 	m := make(map[database.AccountID][]database.BlockTx)
 	mp.mw.RLock()
 	{
-		if number == 0 {
-			number = len(mp.pool)
-		}
-
 		for key, tx := range mp.pool {
 			account := accountFromMapKey(key)
 			m[account] = append(m[account], tx)
@@ -123,7 +181,12 @@ func (mp *MemPool) PickBest(howMany ...uint16) []database.BlockTx {
 }
 
 func mapKey(tx database.BlockTx) string {
-	return fmt.Sprintf("%s:%d", tx.FromID, tx.Nonce)
+	return nonceKey(tx.FromID, tx.Nonce)
+}
+
+// nonceKey constructs the map key for an account and nonce.
+func nonceKey(accountID database.AccountID, nonce uint64) string {
+	return fmt.Sprintf("%s:%d", accountID, nonce)
 }
 
 // accountFromMapKey extracts the account information from the mapkey.
@@ -10,20 +10,37 @@ import (
 // for each account/transaction. This strategy takes into account high-value transactions
 // that happens to be stuck on a low-nonce transaction with a low tip price.
 var advancedTipSelect = func(m map[database.AccountID][]database.BlockTx, howMany int) []database.BlockTx {
-	final := []database.BlockTx{}
 
-	// Sort the transactions per account by nonce.
+	// Sort the transactions per account by nonce and the accounts themselves
+	// so the selection is deterministic.
+	accounts := make([]database.AccountID, 0, len(m))
+	total := 0
 	for key := range m {
 		if len(m[key]) > 1 {
 			sort.Sort(byNonce(m[key]))
 		}
+		accounts = append(accounts, key)
+		total += len(m[key])
 	}
-
-	at := newAdvancedTips(m, howMany)
-	for accountID, transactionNumber := range at.findBest() {
-		for i := 0; i < transactionNumber; i++ {
-			final = append(final, m[accountID][i])
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i] < accounts[j]
+	})
+
+	// When everything fits there is nothing to choose, so the search and its
+	// table are skipped. Receiving 0 means all the transactions are requested.
+	if howMany <= 0 || howMany >= total {
+		final := make([]database.BlockTx, 0, total)
+		for _, accountID := range accounts {
+			final = append(final, m[accountID]...)
 		}
+		return final
+	}
+
+	at := newAdvancedTips(m, accounts, howMany)
+
+	final := make([]database.BlockTx, 0, howMany)
+	for i, take := range at.findBest() {
+		final = append(final, m[accounts[i]][:take]...)
 	}
 
 	return final
@@ -31,73 +48,77 @@ var advancedTipSelect = func(m map[database.AccountID][]database.BlockTx, howMan
 
 // =============================================================================
 
+// advancedTips finds the best set of per-account nonce prefixes. Since a
+// transaction can only be taken together with all the transactions before it,
+// every account offers a group of options (take the first 0, 1, 2... of them)
+// and exactly one option must be chosen per account. This is a grouped
+// knapsack problem which is solved with dynamic programming in
+// O(howMany * number of transactions) time instead of enumerating every
+// combination of prefixes.
 type advancedTips struct {
-	howMany                     int
-	bestTip                     uint64
-	accountToTransactionsNumber map[database.AccountID]int
-	accountCummilativeTips      map[database.AccountID][]uint64
-	accountsList                []database.AccountID
+	howMany         int
+	cumulativeTips  [][]uint64
+	accountsChoices [][]int32
 }
 
-func newAdvancedTips(accountsToTransactionsMap map[database.AccountID][]database.BlockTx, howMany int) *advancedTips {
-	accountCummilativeTips := map[database.AccountID][]uint64{}
-	accounts := []database.AccountID{}
+func newAdvancedTips(m map[database.AccountID][]database.BlockTx, accounts []database.AccountID, howMany int) *advancedTips {
+	cumulativeTips := make([][]uint64, len(accounts))
 
-	for accountID := range accountsToTransactionsMap {
-		accountCummilativeTips[accountID] = []uint64{0}
-		accounts = append(accounts, accountID)
-	}
+	for i, accountID := range accounts {
+		txs := m[accountID]
+		if len(txs) > howMany {
+			txs = txs[:howMany]
+		}
 
-	for accountID, transactions := range accountsToTransactionsMap {
-		for cummilativeTipIndex, tx := range transactions {
-			if cummilativeTipIndex > howMany {
-				break
-			}
-			accountCummilativeTips[accountID] = append(accountCummilativeTips[accountID], tx.Tip+accountCummilativeTips[accountID][cummilativeTipIndex])
+		tips := make([]uint64, len(txs)+1)
+		for j, tx := range txs {
+			tips[j+1] = tips[j] + tx.Tip
 		}
+		cumulativeTips[i] = tips
 	}
 
 	return &advancedTips{
-		howMany:                howMany,
-		accountCummilativeTips: accountCummilativeTips,
-		accountsList:           accounts,
+		howMany:        howMany,
+		cumulativeTips: cumulativeTips,
 	}
 }
 
-func (advancedTip *advancedTips) findBest() map[database.AccountID]int {
-	advancedTip.findBestTransactions(0, 0, advancedTip.howMany, advancedTip.accountToTransactionsNumber, 0)
-	return advancedTip.accountToTransactionsNumber
-}
-
-func (advancedTip *advancedTips) findBestTransactions(accountIndex, pos int, transactionsInBlock int, accountTransactions map[database.AccountID]int, prevTip uint64) {
-	if prevTip > advancedTip.bestTip {
-		advancedTip.bestTip = prevTip
-		advancedTip.accountToTransactionsNumber = accountTransactions
-	}
-
-	if accountIndex >= len(advancedTip.accountsList) {
-		return
-	}
-	accountID := advancedTip.accountsList[accountIndex]
-
-	for tipIndex, cummilativeTip := range advancedTip.accountCummilativeTips[accountID] {
-		if transactionsInBlock-tipIndex < 0 {
-			break
+// findBest returns the number of transactions to take from each account, in
+// the same order as the accounts were provided.
+func (at *advancedTips) findBest() []int {
+
+	// best[c] holds the best total tip using at most c transactions from the
+	// accounts processed so far. choice[i][c] records how many transactions
+	// account i contributed to reach best[c] so the answer can be rebuilt.
+	best := make([]uint64, at.howMany+1)
+	next := make([]uint64, at.howMany+1)
+	at.accountsChoices = make([][]int32, len(at.cumulativeTips))
+
+	for i, tips := range at.cumulativeTips {
+		choice := make([]int32, at.howMany+1)
+
+		for c := 0; c <= at.howMany; c++ {
+			next[c] = best[c]
+			for take := 1; take < len(tips) && take <= c; take++ {
+				if tip := best[c-take] + tips[take]; tip >= next[c] {
+					next[c] = tip
+					choice[c] = int32(take)
+				}
+			}
 		}
 
-		newTipIndex := copyMap(accountTransactions)
-		newTipIndex[accountID] = tipIndex
-		advancedTip.findBestTransactions(accountIndex+1, tipIndex, transactionsInBlock-tipIndex, newTipIndex, prevTip+cummilativeTip)
+		at.accountsChoices[i] = choice
+		best, next = next, best
 	}
-}
-
-// =============================================================================
 
-func copyMap(m map[database.AccountID]int) map[database.AccountID]int {
-	newCurrPos := map[database.AccountID]int{}
-	for from, pos := range m {
-		newCurrPos[from] = pos
+	// Walk the choices backwards to find what each account contributed.
+	result := make([]int, len(at.cumulativeTips))
+	c := at.howMany
+	for i := len(at.accountsChoices) - 1; i >= 0; i-- {
+		take := int(at.accountsChoices[i][c])
+		result[i] = take
+		c -= take
 	}
 
-	return newCurrPos
+	return result
 }
@@ -0,0 +1,133 @@
+package selector_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool/selector"
+)
+
+func TestAdvancedTipsBestTip(t *testing.T) {
+	fn, err := selector.Retrieve(selector.StrategyTipAdvanced)
+	if err != nil {
+		t.Fatalf("retrieving strategy: %s", err)
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+
+	for pool := 0; pool < 200; pool++ {
+		src := newRandomPool(rnd, 1+rnd.Intn(4), 4, 20)
+
+		for _, howMany := range []int{1, 2, 3, 5, 8} {
+			t.Run(fmt.Sprintf("pool[%d]/howMany[%d]", pool, howMany), func(t *testing.T) {
+				got := fn(copyPool(src), howMany)
+
+				if len(got) > howMany {
+					t.Fatalf("got %d transactions, expected at most %d", len(got), howMany)
+				}
+				checkPrefixes(t, src, got)
+
+				if tip, best := totalTip(got), bruteForceTip(src, howMany); tip != best {
+					t.Errorf("got total tip %d, expected %d", tip, best)
+				}
+			})
+		}
+	}
+}
+
+// =============================================================================
+
+// newRandomPool constructs a pool with the specified number of accounts, each
+// holding between 1 and perAcct transactions with tips up to maxTip.
+func newRandomPool(rnd *rand.Rand, accounts int, perAcct int, maxTip int) map[database.AccountID][]database.BlockTx {
+	m := make(map[database.AccountID][]database.BlockTx, accounts)
+	for a := 0; a < accounts; a++ {
+		accountID := database.AccountID(fmt.Sprintf("0x%040x", a+1))
+
+		txs := make([]database.BlockTx, 1+rnd.Intn(perAcct))
+		for n := range txs {
+			txs[n] = database.BlockTx{
+				SignedTx: database.SignedTx{
+					Tx: database.Tx{
+						FromID: accountID,
+						Nonce:  uint64(n + 1),
+						Value:  1,
+						Tip:    uint64(rnd.Intn(maxTip + 1)),
+					},
+				},
+				GasPrice: 15,
+				GasUnits: 1,
+			}
+		}
+
+		// The strategies must sort the transactions themselves.
+		rnd.Shuffle(len(txs), func(i, j int) { txs[i], txs[j] = txs[j], txs[i] })
+		m[accountID] = txs
+	}
+
+	return m
+}
+
+// bruteForceTip returns the best total tip of at most howMany transactions by
+// trying every combination of per-account nonce prefixes.
+func bruteForceTip(m map[database.AccountID][]database.BlockTx, howMany int) uint64 {
+	var prefixTips [][]uint64
+	for _, txs := range m {
+		byNonce := make([]uint64, len(txs))
+		for _, tx := range txs {
+			byNonce[tx.Nonce-1] = tx.Tip
+		}
+
+		tips := make([]uint64, len(txs)+1)
+		for n, tip := range byNonce {
+			tips[n+1] = tips[n] + tip
+		}
+		prefixTips = append(prefixTips, tips)
+	}
+
+	var search func(account int, left int) uint64
+	search = func(account int, left int) uint64 {
+		if account == len(prefixTips) {
+			return 0
+		}
+
+		var best uint64
+		for take := 0; take < len(prefixTips[account]) && take <= left; take++ {
+			if tip := prefixTips[account][take] + search(account+1, left-take); tip > best {
+				best = tip
+			}
+		}
+		return best
+	}
+
+	return search(0, howMany)
+}
+
+// checkPrefixes checks the transactions of every account start at its first
+// nonce and follow in nonce order without gaps.
+func checkPrefixes(t *testing.T, m map[database.AccountID][]database.BlockTx, got []database.BlockTx) {
+	t.Helper()
+
+	next := make(map[database.AccountID]uint64, len(m))
+	for _, tx := range got {
+		if _, exists := m[tx.FromID]; !exists {
+			t.Fatalf("transaction from unknown account %s", tx.FromID)
+		}
+		if expected := next[tx.FromID] + 1; tx.Nonce != expected {
+			t.Fatalf("account %s: got nonce %d, expected %d", tx.FromID, tx.Nonce, expected)
+		}
+		next[tx.FromID] = tx.Nonce
+	}
+}
+
+// totalTip returns the sum of the tips of the transactions.
+func totalTip(txs []database.BlockTx) uint64 {
+	var total uint64
+	for _, tx := range txs {
+		total += tx.Tip
+	}
+
+	return total
+}
@@ -0,0 +1,102 @@
+package selector
+
+import (
+	"fmt"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+)
+
+// conformancePool describes the transactions used to check a strategy. The
+// tips are set up so a strategy that only looks at tips would be tempted to
+// pick a transaction ahead of one with a lower nonce.
+var conformancePool = map[database.AccountID][]uint64{
+	"0xF01813E4B85e178A83e29B8E7bF26BD830a25f32": {10, 500, 20, 900},
+	"0xdd6B972ffcc631a62CAE1BB9d80b7ff429c8ebA4": {300, 5},
+	"0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76": {0, 0, 1000},
+	"0x6Fe6CF3c8fF57c58d24BfC869668F48BCbDb3BD9": {75},
+	"0xa988b1866EaBF72B4c53b592c97aAD8e4b9bDCC0": {150, 150, 150},
+}
+
+// Conformance runs the checks every select strategy must pass against a
+// small pool of transactions. For every value of howMany the strategy must
+// return each account's transactions in nonce order without skipping a
+// nonce, never return any transaction twice, and return exactly howMany
+// transactions or every transaction when there are fewer. Receiving 0 must
+// return every transaction. The pool uses one unit of gas and a few bytes
+// per transaction, so no block limit can justify returning fewer. Teams
+// that write their own strategy can call this from their tests before
+// registering it.
+func Conformance(fn Func) error {
+	total := 0
+	for _, tips := range conformancePool {
+		total += len(tips)
+	}
+
+	for howMany := 0; howMany <= total+1; howMany++ {
+		final := fn(newConformancePool(), howMany)
+
+		if err := checkNonceOrder(final); err != nil {
+			return fmt.Errorf("howMany[%d]: %w", howMany, err)
+		}
+
+		expected := howMany
+		if howMany == 0 || howMany > total {
+			expected = total
+		}
+
+		if len(final) != expected {
+			return fmt.Errorf("howMany[%d]: got %d transactions, expected %d", howMany, len(final), expected)
+		}
+	}
+
+	return nil
+}
+
+// newConformancePool constructs a fresh copy of the conformance pool since
+// strategies are allowed to modify what they receive. Transactions are
+// stored with the nonces out of order like the mempool can provide them.
+func newConformancePool() map[database.AccountID][]database.BlockTx {
+	m := make(map[database.AccountID][]database.BlockTx, len(conformancePool))
+
+	for accountID, tips := range conformancePool {
+		txs := make([]database.BlockTx, len(tips))
+		for i, tip := range tips {
+			tx := database.BlockTx{
+				SignedTx: database.SignedTx{
+					Tx: database.Tx{
+						FromID: accountID,
+						Nonce:  uint64(i + 1),
+						Value:  1,
+						Tip:    tip,
+					},
+				},
+				GasPrice: 15,
+				GasUnits: 1,
+			}
+			txs[len(tips)-1-i] = tx
+		}
+		m[accountID] = txs
+	}
+
+	return m
+}
+
+// checkNonceOrder validates that for every account the selected transactions
+// start at the lowest nonce in the pool and follow each other without gaps.
+func checkNonceOrder(final []database.BlockTx) error {
+	next := make(map[database.AccountID]uint64)
+
+	for _, tx := range final {
+		if _, exists := conformancePool[tx.FromID]; !exists {
+			return fmt.Errorf("unknown transaction %s", tx)
+		}
+
+		expected := next[tx.FromID] + 1
+		if tx.Nonce != expected {
+			return fmt.Errorf("transaction %s selected out of nonce order, expected nonce %d", tx, expected)
+		}
+		next[tx.FromID] = expected
+	}
+
+	return nil
+}
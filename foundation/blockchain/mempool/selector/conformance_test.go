@@ -0,0 +1,138 @@
+package selector_test
+
+import (
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool/selector"
+)
+
+func TestConformance(t *testing.T) {
+	for _, strategy := range selector.Strategies() {
+		t.Run(strategy, func(t *testing.T) {
+			fn, err := selector.Retrieve(strategy)
+			if err != nil {
+				t.Fatalf("retrieving strategy: %s", err)
+			}
+
+			if err := selector.Conformance(fn); err != nil {
+				t.Errorf("strategy doesn't conform: %s", err)
+			}
+		})
+	}
+}
+
+func TestConformanceLimits(t *testing.T) {
+	limits := selector.Limits{
+		MaxGas:   1_000_000,
+		MaxBytes: 1 << 20,
+	}
+
+	fn, err := selector.RetrieveWithLimits(selector.StrategyFeeDensity, limits)
+	if err != nil {
+		t.Fatalf("retrieving strategy: %s", err)
+	}
+
+	if err := selector.Conformance(fn); err != nil {
+		t.Errorf("strategy doesn't conform: %s", err)
+	}
+}
+
+func TestRegisterRejects(t *testing.T) {
+	tip, err := selector.Retrieve(selector.StrategyTip)
+	if err != nil {
+		t.Fatalf("retrieving strategy: %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		strategy string
+		fn       selector.Func
+	}{
+		{
+			name:     "exact duplicate",
+			strategy: selector.StrategyTip,
+			fn:       tip,
+		},
+		{
+			name:     "case variant duplicate",
+			strategy: "Tip_Advanced",
+			fn:       tip,
+		},
+		{
+			name:     "strategy that considers the block limits",
+			strategy: selector.StrategyFeeDensity,
+			fn:       tip,
+		},
+		{
+			name:     "case variant of a strategy that considers the block limits",
+			strategy: "FEE_DENSITY",
+			fn:       tip,
+		},
+		{
+			name:     "no name",
+			strategy: "",
+			fn:       tip,
+		},
+		{
+			name:     "no function",
+			strategy: "bad_no_function",
+		},
+		{
+			name:     "nothing unless all",
+			strategy: "bad_nothing_unless_all",
+			fn: func(m map[database.AccountID][]database.BlockTx, howMany int) []database.BlockTx {
+				if howMany > 0 {
+					return nil
+				}
+				return selector.NewFeeDensity(selector.Limits{})(m, 0)
+			},
+		},
+		{
+			name:     "one per account",
+			strategy: "bad_one_per_account",
+			fn: func(m map[database.AccountID][]database.BlockTx, howMany int) []database.BlockTx {
+				var final []database.BlockTx
+				for _, txs := range m {
+					for _, tx := range txs {
+						if tx.Nonce == 1 {
+							final = append(final, tx)
+						}
+					}
+				}
+				return final
+			},
+		},
+		{
+			name:     "out of nonce order",
+			strategy: "bad_out_of_nonce_order",
+			fn: func(m map[database.AccountID][]database.BlockTx, howMany int) []database.BlockTx {
+				var final []database.BlockTx
+				for _, txs := range m {
+					final = append(final, txs...)
+				}
+				if howMany > 0 && len(final) > howMany {
+					final = final[:howMany]
+				}
+				return final
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := selector.Register(tt.strategy, tt.fn); err == nil {
+				t.Error("strategy was registered")
+			}
+		})
+	}
+
+	// The duplicates must not have replaced the strategies they collided
+	// with.
+	if fn, err := selector.RetrieveWithLimits("Fee_Density", selector.Limits{MaxGas: 1}); err != nil || fn == nil {
+		t.Errorf("retrieving fee density: %v", err)
+	}
+	if len(selector.Strategies()) != 3 {
+		t.Errorf("got strategies %v", selector.Strategies())
+	}
+}
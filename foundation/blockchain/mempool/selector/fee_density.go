@@ -0,0 +1,255 @@
+package selector
+
+import (
+	"container/heap"
+	"sort"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+)
+
+// Limits represents the resources a block has available. A zero value for
+// any field means that resource is not limited.
+type Limits struct {
+	MaxGas   uint64
+	MaxBytes uint64
+}
+
+// NewFeeDensity returns a strategy that maximizes the miner's revenue (tip
+// plus gas fee) for a block that must fit within howMany transactions and
+// the specified gas and byte limits. When howMany is 0 every transaction is
+// returned, so the limits don't apply.
+//
+// Picking the perfect set is a multi-dimensional knapsack problem, so this
+// uses a greedy approximation. Each transaction is weighed by the largest
+// share of a block resource it consumes and accounts are compared by the
+// revenue per weight of their next run of transactions. Looking at runs
+// instead of single transactions lets a cheap transaction be selected when
+// it's holding back expensive ones with a higher nonce.
+func NewFeeDensity(limits Limits) Func {
+	return func(m map[database.AccountID][]database.BlockTx, howMany int) []database.BlockTx {
+		total := 0
+		for key := range m {
+			if len(m[key]) > 1 {
+				sort.Sort(byNonce(m[key]))
+			}
+			total += len(m[key])
+		}
+
+		limits := limits
+		if howMany <= 0 {
+			limits = Limits{}
+		}
+
+		if howMany <= 0 || howMany > total {
+			howMany = total
+		}
+
+		fd := newFeeDensity(m, limits, howMany)
+		return fd.selectTxs()
+	}
+}
+
+// =============================================================================
+
+// feeTx holds the values the fee density strategy needs for a transaction.
+type feeTx struct {
+	tx      database.BlockTx
+	revenue uint64
+	gas     uint64
+	bytes   uint64
+	weight  float64
+}
+
+// feeAccount tracks the transactions of an account still to be selected and
+// the run of them that currently offers the best density.
+type feeAccount struct {
+	accountID database.AccountID
+	txs       []feeTx
+	run       int
+	density   float64
+}
+
+// feeDensity performs a single selection.
+type feeDensity struct {
+	limits   Limits
+	howMany  int
+	gasUsed  uint64
+	bytes    uint64
+	accounts feeHeap
+}
+
+func newFeeDensity(m map[database.AccountID][]database.BlockTx, limits Limits, howMany int) *feeDensity {
+	fd := feeDensity{
+		limits:  limits,
+		howMany: howMany,
+	}
+
+	for accountID, txs := range m {
+		fa := feeAccount{
+			accountID: accountID,
+			txs:       make([]feeTx, len(txs)),
+		}
+
+		for i, tx := range txs {
+			fa.txs[i] = fd.newFeeTx(tx)
+		}
+
+		if fa.bestRun() {
+			fd.accounts = append(fd.accounts, &fa)
+		}
+	}
+
+	heap.Init(&fd.accounts)
+
+	return &fd
+}
+
+// newFeeTx weighs the transaction by the largest share of a block resource
+// it consumes. Every transaction uses at least one slot of the count limit.
+func (fd *feeDensity) newFeeTx(tx database.BlockTx) feeTx {
+	ft := feeTx{
+		tx:      tx,
+		revenue: tx.Tip + tx.GasPrice*tx.GasUnits,
+		gas:     tx.GasUnits,
+		bytes:   txSize(tx),
+		weight:  1 / float64(fd.howMany),
+	}
+
+	if fd.limits.MaxGas > 0 {
+		if w := float64(ft.gas) / float64(fd.limits.MaxGas); w > ft.weight {
+			ft.weight = w
+		}
+	}
+
+	if fd.limits.MaxBytes > 0 {
+		if w := float64(ft.bytes) / float64(fd.limits.MaxBytes); w > ft.weight {
+			ft.weight = w
+		}
+	}
+
+	return ft
+}
+
+// selectTxs keeps taking the densest run that fits until the block is full
+// or there is nothing left.
+func (fd *feeDensity) selectTxs() []database.BlockTx {
+	final := make([]database.BlockTx, 0, fd.howMany)
+
+	for fd.accounts.Len() > 0 && len(final) < fd.howMany {
+		fa := fd.accounts[0]
+
+		// Take as much of the run as fits. A transaction that doesn't fit
+		// blocks everything after it for this account.
+		taken := 0
+		for _, ft := range fa.txs[:fa.run] {
+			if !fd.fits(ft, len(final)) {
+				break
+			}
+			final = append(final, ft.tx)
+			fd.gasUsed += ft.gas
+			fd.bytes += ft.bytes
+			taken++
+		}
+
+		if taken < fa.run {
+			heap.Pop(&fd.accounts)
+			continue
+		}
+
+		fa.txs = fa.txs[taken:]
+		if !fa.bestRun() {
+			heap.Pop(&fd.accounts)
+			continue
+		}
+		heap.Fix(&fd.accounts, 0)
+	}
+
+	return final
+}
+
+// fits reports whether the transaction can still be added to the block.
+func (fd *feeDensity) fits(ft feeTx, selected int) bool {
+	if selected >= fd.howMany {
+		return false
+	}
+
+	if fd.limits.MaxGas > 0 && fd.gasUsed+ft.gas > fd.limits.MaxGas {
+		return false
+	}
+
+	if fd.limits.MaxBytes > 0 && fd.bytes+ft.bytes > fd.limits.MaxBytes {
+		return false
+	}
+
+	return true
+}
+
+// bestRun finds the run of transactions from the front of the account's
+// list with the best revenue per weight. It returns false when there are no
+// transactions left.
+func (fa *feeAccount) bestRun() bool {
+	fa.run = 0
+	fa.density = 0
+
+	var revenue uint64
+	var weight float64
+	for i, ft := range fa.txs {
+		revenue += ft.revenue
+		weight += ft.weight
+
+		if d := float64(revenue) / weight; fa.run == 0 || d > fa.density {
+			fa.run = i + 1
+			fa.density = d
+		}
+	}
+
+	return fa.run > 0
+}
+
+// txSize returns the number of bytes the transaction takes when encoded. The
+// mempool sets it when the transaction is added, so encoding is only needed
+// for transactions that didn't come from it.
+func txSize(tx database.BlockTx) uint64 {
+	if tx.Size > 0 {
+		return tx.Size
+	}
+
+	return tx.EncodedSize()
+}
+
+// =============================================================================
+
+// feeHeap orders accounts by the density of their best run.
+type feeHeap []*feeAccount
+
+// Len returns the number of accounts in the heap.
+func (fh feeHeap) Len() int {
+	return len(fh)
+}
+
+// Less puts the account with the densest run at the top of the heap.
+func (fh feeHeap) Less(i, j int) bool {
+	if fh[i].density == fh[j].density {
+		return fh[i].accountID < fh[j].accountID
+	}
+	return fh[i].density > fh[j].density
+}
+
+// Swap moves accounts inside the heap.
+func (fh feeHeap) Swap(i, j int) {
+	fh[i], fh[j] = fh[j], fh[i]
+}
+
+// Push adds an account to the heap.
+func (fh *feeHeap) Push(x any) {
+	*fh = append(*fh, x.(*feeAccount))
+}
+
+// Pop removes the last account from the heap.
+func (fh *feeHeap) Pop() any {
+	old := *fh
+	n := len(old)
+	fa := old[n-1]
+	*fh = old[:n-1]
+	return fa
+}
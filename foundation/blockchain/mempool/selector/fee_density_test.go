@@ -0,0 +1,149 @@
+package selector_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool/selector"
+)
+
+func TestFeeDensityLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   selector.Limits
+		howMany  int
+		txs      []database.BlockTx
+		expected []string // Account and nonce of the selected transactions, in order.
+	}{
+		{
+			name:    "gas limit leaves out the least dense",
+			limits:  selector.Limits{MaxGas: 10},
+			howMany: 10,
+			txs: []database.BlockTx{
+				feeTx("a", 1, 50, 10, 100),
+				feeTx("b", 1, 20, 2, 100),
+				feeTx("c", 1, 18, 3, 100),
+			},
+			expected: []string{"b1", "c1"},
+		},
+		{
+			name:    "byte limit leaves out the least dense",
+			limits:  selector.Limits{MaxBytes: 1000},
+			howMany: 10,
+			txs: []database.BlockTx{
+				feeTx("a", 1, 100, 1, 900),
+				feeTx("b", 1, 60, 1, 300),
+				feeTx("c", 1, 50, 1, 300),
+			},
+			expected: []string{"b1", "c1"},
+		},
+		{
+			name:    "cheap transaction holding back an expensive one",
+			limits:  selector.Limits{MaxGas: 3},
+			howMany: 10,
+			txs: []database.BlockTx{
+				feeTx("a", 1, 0, 1, 100),
+				feeTx("a", 2, 100, 1, 100),
+				feeTx("b", 1, 30, 1, 100),
+				feeTx("c", 1, 20, 1, 100),
+			},
+			expected: []string{"a1", "a2", "b1"},
+		},
+		{
+			name:    "transaction that doesn't fit blocks the rest of its account",
+			limits:  selector.Limits{MaxGas: 5},
+			howMany: 10,
+			txs: []database.BlockTx{
+				feeTx("a", 1, 100, 4, 100),
+				feeTx("a", 2, 100, 4, 100),
+				feeTx("a", 3, 100, 1, 100),
+				feeTx("b", 1, 10, 1, 100),
+			},
+			expected: []string{"a1", "b1"},
+		},
+		{
+			name:    "count limit before the gas limit",
+			limits:  selector.Limits{MaxGas: 100},
+			howMany: 2,
+			txs: []database.BlockTx{
+				feeTx("a", 1, 10, 1, 100),
+				feeTx("b", 1, 30, 1, 100),
+				feeTx("c", 1, 20, 1, 100),
+			},
+			expected: []string{"b1", "c1"},
+		},
+		{
+			name:    "all transactions ignore the limits",
+			limits:  selector.Limits{MaxGas: 1, MaxBytes: 1},
+			howMany: 0,
+			txs: []database.BlockTx{
+				feeTx("a", 2, 10, 5, 100),
+				feeTx("a", 1, 10, 5, 100),
+				feeTx("b", 1, 30, 5, 100),
+			},
+			expected: []string{"b1", "a1", "a2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn, err := selector.RetrieveWithLimits(selector.StrategyFeeDensity, tt.limits)
+			if err != nil {
+				t.Fatalf("retrieving strategy: %s", err)
+			}
+
+			m := make(map[database.AccountID][]database.BlockTx)
+			for _, tx := range tt.txs {
+				m[tx.FromID] = append(m[tx.FromID], tx)
+			}
+
+			var got []string
+			for _, tx := range fn(m, tt.howMany) {
+				got = append(got, fmt.Sprintf("%s%d", accountName(tx.FromID), tx.Nonce))
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Errorf("got %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+// =============================================================================
+
+// feeTxAccounts maps the short names the tests use to accounts.
+var feeTxAccounts = map[string]database.AccountID{
+	"a": "0x000000000000000000000000000000000000000a",
+	"b": "0x000000000000000000000000000000000000000b",
+	"c": "0x000000000000000000000000000000000000000c",
+}
+
+// feeTx constructs a transaction from the named account with a gas price of
+// one, so its revenue is its tip plus its gas units.
+func feeTx(account string, nonce uint64, tip uint64, gasUnits uint64, size uint64) database.BlockTx {
+	return database.BlockTx{
+		SignedTx: database.SignedTx{
+			Tx: database.Tx{
+				FromID: feeTxAccounts[account],
+				Nonce:  nonce,
+				Value:  1,
+				Tip:    tip,
+			},
+		},
+		GasPrice: 1,
+		GasUnits: gasUnits,
+		Size:     size,
+	}
+}
+
+// accountName returns the short name of the account.
+func accountName(accountID database.AccountID) string {
+	for name, id := range feeTxAccounts {
+		if id == accountID {
+			return name
+		}
+	}
+
+	return string(accountID)
+}
@@ -2,8 +2,11 @@
 package selector
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
 )
@@ -12,12 +15,24 @@ import (
 const (
 	StrategyTip         = "tip"
 	StrategyTipAdvanced = "tip_advanced"
+	StrategyFeeDensity  = "fee_density"
 )
 
 // Map of different select strategies with functions.
-var strategies = map[string]Func{
-	StrategyTip:         tipSelect,
-	StrategyTipAdvanced: advancedTipSelect,
+var strategies = struct {
+	sync.RWMutex
+	m map[string]Func
+}{
+	m: map[string]Func{
+		StrategyTip:         tipSelect,
+		StrategyTipAdvanced: advancedTipSelect,
+	},
+}
+
+// Map of the strategies that consider the block limits. They are constructed
+// with the limits they are retrieved with.
+var limitedStrategies = map[string]func(Limits) Func{
+	StrategyFeeDensity: NewFeeDensity,
 }
 
 // Func defines a function that takes a mempool of transactions grouped by
@@ -26,15 +41,82 @@ var strategies = map[string]Func{
 // for howMany must return all the transactions in the strategies ordering.
 type Func func(transactions map[database.AccountID][]database.BlockTx, howMany int) []database.BlockTx
 
-// Retrieve returns the specified select strategy function.
+// Register adds a custom select strategy under the specified name so it can
+// be picked through configuration. Names are case insensitive and can't be
+// registered twice. The strategy must pass the Conformance checks.
+func Register(strategy string, fn Func) error {
+	name := strings.ToLower(strategy)
+	if name == "" {
+		return errors.New("strategy name is required")
+	}
+
+	if fn == nil {
+		return fmt.Errorf("strategy %q has no function", strategy)
+	}
+
+	if err := Conformance(fn); err != nil {
+		return fmt.Errorf("strategy %q: %w", strategy, err)
+	}
+
+	strategies.Lock()
+	defer strategies.Unlock()
+
+	if _, exists := limitedStrategies[name]; exists {
+		return fmt.Errorf("strategy %q already registered", strategy)
+	}
+
+	if _, exists := strategies.m[name]; exists {
+		return fmt.Errorf("strategy %q already registered", strategy)
+	}
+	strategies.m[name] = fn
+
+	return nil
+}
+
+// Retrieve returns the specified select strategy function. Strategies that
+// consider the block limits don't limit anything but the number of
+// transactions.
 func Retrieve(strategy string) (Func, error) {
-	fn, exists := strategies[strings.ToLower(strategy)]
+	return RetrieveWithLimits(strategy, Limits{})
+}
+
+// RetrieveWithLimits returns the specified select strategy function for
+// blocks with the specified limits. Strategies that don't consider the block
+// limits ignore them.
+func RetrieveWithLimits(strategy string, limits Limits) (Func, error) {
+	name := strings.ToLower(strategy)
+
+	if newFn, exists := limitedStrategies[name]; exists {
+		return newFn(limits), nil
+	}
+
+	strategies.RLock()
+	defer strategies.RUnlock()
+
+	fn, exists := strategies.m[name]
 	if !exists {
 		return nil, fmt.Errorf("strategy %q does not exist", strategy)
 	}
 	return fn, nil
 }
 
+// Strategies returns the names of all the registered strategies.
+func Strategies() []string {
+	strategies.RLock()
+	defer strategies.RUnlock()
+
+	names := make([]string, 0, len(strategies.m)+len(limitedStrategies))
+	for name := range strategies.m {
+		names = append(names, name)
+	}
+	for name := range limitedStrategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
 // =============================================================================
 
 // byNonce provides sorting support by the transaction id value.
@@ -0,0 +1,124 @@
+package selector_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool/selector"
+)
+
+// benchPools are the shapes of mempool the strategies are measured with:
+// how many accounts hold pending transactions and how many each one holds.
+var benchPools = []struct {
+	accounts int
+	perAcct  int
+}{
+	{accounts: 10, perAcct: 5},
+	{accounts: 100, perAcct: 5},
+	{accounts: 100, perAcct: 50},
+}
+
+func BenchmarkTips(b *testing.B) {
+	benchmarkStrategy(b, selector.StrategyTip)
+}
+
+func BenchmarkAdvancedTips(b *testing.B) {
+	benchmarkStrategy(b, selector.StrategyTipAdvanced)
+}
+
+// BenchmarkFeeDensity measures the strategy with gas and byte limits that
+// fill the block before the count limit does.
+func BenchmarkFeeDensity(b *testing.B) {
+	fn, err := selector.RetrieveWithLimits(selector.StrategyFeeDensity, selector.Limits{
+		MaxGas:   8,
+		MaxBytes: 7 * benchTxSize,
+	})
+	if err != nil {
+		b.Fatalf("retrieving strategy: %s", err)
+	}
+
+	benchmarkFunc(b, fn)
+}
+
+// benchmarkStrategy measures picking a block worth of transactions and
+// listing the whole pool, which is what PickBest is called for.
+func benchmarkStrategy(b *testing.B, strategy string) {
+	fn, err := selector.Retrieve(strategy)
+	if err != nil {
+		b.Fatalf("retrieving strategy: %s", err)
+	}
+
+	benchmarkFunc(b, fn)
+}
+
+// benchmarkFunc measures the strategy function against every pool shape.
+func benchmarkFunc(b *testing.B, fn selector.Func) {
+	for _, pool := range benchPools {
+		for _, howMany := range []int{10, 0} {
+			name := fmt.Sprintf("accounts[%d]/perAcct[%d]/howMany[%d]", pool.accounts, pool.perAcct, howMany)
+
+			b.Run(name, func(b *testing.B) {
+				src := newBenchPool(pool.accounts, pool.perAcct)
+
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					// Strategies are allowed to modify what they receive.
+					b.StopTimer()
+					m := copyPool(src)
+					b.StartTimer()
+
+					fn(m, howMany)
+				}
+			})
+		}
+	}
+}
+
+// benchTxSize is the encoded size of the transactions in the benchmark pools,
+// which the mempool sets when a transaction is added.
+const benchTxSize = 200
+
+// newBenchPool constructs a pool with random tips. The seed is fixed so
+// every run measures the same pool.
+func newBenchPool(accounts int, perAcct int) map[database.AccountID][]database.BlockTx {
+	rnd := rand.New(rand.NewSource(1))
+
+	m := make(map[database.AccountID][]database.BlockTx, accounts)
+	for a := 0; a < accounts; a++ {
+		accountID := database.AccountID(fmt.Sprintf("0x%040x", a+1))
+
+		txs := make([]database.BlockTx, perAcct)
+		for n := range txs {
+			txs[n] = database.BlockTx{
+				SignedTx: database.SignedTx{
+					Tx: database.Tx{
+						FromID: accountID,
+						Nonce:  uint64(n + 1),
+						Value:  1,
+						Tip:    uint64(rnd.Intn(1000)),
+					},
+				},
+				GasPrice: 15,
+				GasUnits: 1,
+				Size:     benchTxSize,
+			}
+		}
+		m[accountID] = txs
+	}
+
+	return m
+}
+
+// copyPool returns a copy of the pool that can be handed to a strategy.
+func copyPool(src map[database.AccountID][]database.BlockTx) map[database.AccountID][]database.BlockTx {
+	m := make(map[database.AccountID][]database.BlockTx, len(src))
+	for accountID, txs := range src {
+		m[accountID] = append([]database.BlockTx(nil), txs...)
+	}
+
+	return m
+}
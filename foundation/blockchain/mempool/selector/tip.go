@@ -26,10 +26,17 @@ var tipSelect = func(m map[database.AccountID][]database.BlockTx, howMany int) [
 	*/
 
 	// Sort the transactions per account by nonce.
+	total := 0
 	for key := range m {
 		if len(m[key]) > 1 {
 			sort.Sort(byNonce(m[key]))
 		}
+		total += len(m[key])
+	}
+
+	// Receiving 0 means all the transactions are requested.
+	if howMany <= 0 {
+		howMany = total
 	}
 
 	/*
@@ -0,0 +1,194 @@
+// Package peer maintains the peer related information such as the set
+// of known peers and their status.
+package peer
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Peer represents information about a Node in the network.
+type Peer struct {
+	Host string `json:"host"`
+}
+
+// New constructs a new info value.
+func New(host string) Peer {
+	return Peer{
+		Host: host,
+	}
+}
+
+// Match validates if the specified host matches this node.
+func (p Peer) Match(host string) bool {
+	return p.Host == host
+}
+
+// =============================================================================
+
+// Status represents information about the status of any given peer.
+type Status struct {
+	Build             string `json:"build"`
+	GenesisHash       string `json:"genesis_hash"`
+	LatestBlockHash   string `json:"latest_block_hash"`
+	LatestBlockNumber uint64 `json:"latest_block_number"`
+	MempoolLength     int64  `json:"mempool_length"`
+	Mining            bool   `json:"mining"`
+	MiningPaused      bool   `json:"mining_paused"`
+	PeerCount         int    `json:"peer_count"`
+	KnownPeers        []Peer `json:"known_peers"`
+}
+
+// Info represents what this node knows about the health of a peer.
+type Info struct {
+	Host              string    `json:"host"`
+	Seed              bool      `json:"seed"`
+	Failures          int       `json:"failures"`
+	LastSeen          time.Time `json:"last_seen"`
+	LatestBlockNumber uint64    `json:"latest_block_number"`
+}
+
+// =============================================================================
+
+// health tracks how a peer has been responding.
+type health struct {
+	seed              bool
+	failures          int
+	lastSeen          time.Time
+	latestBlockNumber uint64
+}
+
+// Set represents the data representation to maintain a set of known peers.
+type Set struct {
+	mu  sync.RWMutex
+	set map[Peer]health
+}
+
+// NewSet constructs a new info set to manage node peer information.
+func NewSet() *Set {
+	return &Set{
+		set: make(map[Peer]health),
+	}
+}
+
+// Add adds a new node to the set. It returns false if the peer was
+// already in the set.
+func (s *Set) Add(peer Peer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.set[peer]; exists {
+		return false
+	}
+	s.set[peer] = health{}
+
+	return true
+}
+
+// AddSeed adds a node from configuration to the set. Seed peers are never
+// removed for being unreachable, so the node can always find its way back
+// into the network.
+func (s *Set) AddSeed(peer Peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h := s.set[peer]
+	h.seed = true
+	s.set[peer] = h
+}
+
+// Remove removes a node from the set. It returns false if the peer was
+// not in the set.
+func (s *Set) Remove(peer Peer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.set[peer]; !exists {
+		return false
+	}
+	delete(s.set, peer)
+
+	return true
+}
+
+// Copy returns a list of the known peers, excluding the specified host.
+func (s *Set) Copy(host string) []Peer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var peers []Peer
+	for peer := range s.set {
+		if !peer.Match(host) {
+			peers = append(peers, peer)
+		}
+	}
+
+	return peers
+}
+
+// Info returns the health information for the known peers, excluding the
+// specified host, sorted by host.
+func (s *Set) Info(host string) []Info {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	infos := make([]Info, 0, len(s.set))
+	for peer, h := range s.set {
+		if peer.Match(host) {
+			continue
+		}
+
+		infos = append(infos, Info{
+			Host:              peer.Host,
+			Seed:              h.seed,
+			Failures:          h.failures,
+			LastSeen:          h.lastSeen,
+			LatestBlockNumber: h.latestBlockNumber,
+		})
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Host < infos[j].Host
+	})
+
+	return infos
+}
+
+// RecordSuccess records that the peer responded with the specified status.
+func (s *Set) RecordSuccess(peer Peer, status Status) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, exists := s.set[peer]
+	if !exists {
+		return
+	}
+
+	h.failures = 0
+	h.lastSeen = time.Now().UTC()
+	h.latestBlockNumber = status.LatestBlockNumber
+	s.set[peer] = h
+}
+
+// RecordFailure records that the peer could not be reached or served blocks
+// that failed validation. Once a peer that isn't a seed fails maxFailures
+// times in a row it's removed from the set and true is returned.
+func (s *Set) RecordFailure(peer Peer, maxFailures int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, exists := s.set[peer]
+	if !exists {
+		return false
+	}
+
+	h.failures++
+	if !h.seed && h.failures >= maxFailures {
+		delete(s.set, peer)
+		return true
+	}
+	s.set[peer] = h
+
+	return false
+}
@@ -0,0 +1,177 @@
+package simulator
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a manual clock every node in the network runs on. It time stamps
+// the blocks, fires the tickers of the workers and times the latency of the
+// transport. Time only moves when Advance is called, so nothing that depends
+// on time happens between two calls.
+//
+// The clock also counts the work that is left to do, which is what makes
+// the network deterministic. A tick or a timer counts as work from the
+// moment it fires until whoever received it calls End. The workers report
+// the signals they queue through Begin and End as well, so once the count
+// drops to zero nothing happens until the clock moves again. It implements
+// worker.Tracker.
+type Clock struct {
+	mu      sync.Mutex
+	idle    *sync.Cond
+	now     time.Time
+	waiters []*waiter
+	busy    int
+}
+
+// waiter is a ticker or a timer waiting for the clock to reach a time.
+type waiter struct {
+	at     time.Time
+	period time.Duration // Zero for a timer, which fires once.
+	c      chan time.Time
+}
+
+// NewClock constructs a clock set to the specified time.
+func NewClock(start time.Time) *Clock {
+	c := Clock{
+		now: start,
+	}
+	c.idle = sync.NewCond(&c.mu)
+
+	return &c
+}
+
+// Now returns the current time of the clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Advance moves the clock forward and fires the tickers and timers that are
+// due. Like the tickers of the time package, a ticker whose last tick wasn't
+// received yet drops the ticks in between.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiters = append(waiters, w)
+			continue
+		}
+
+		select {
+		case w.c <- c.now:
+			c.busy++
+		default:
+		}
+
+		if w.period == 0 {
+			continue
+		}
+
+		for !w.at.After(c.now) {
+			w.at = w.at.Add(w.period)
+		}
+		waiters = append(waiters, w)
+	}
+	c.waiters = waiters
+}
+
+// NewTicker starts a ticker that ticks every time the clock moves past the
+// period. It matches worker.TickerFunc.
+func (c *Clock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w := waiter{
+		at:     c.now.Add(d),
+		period: d,
+		c:      make(chan time.Time, 1),
+	}
+	c.waiters = append(c.waiters, &w)
+
+	return w.c, func() { c.remove(&w) }
+}
+
+// After returns a channel that receives the time once the clock has moved
+// forward by the specified duration, and a function that stops the timer.
+// The receiver must call End once it handled the time.
+func (c *Clock) After(d time.Duration) (<-chan time.Time, func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w := waiter{
+		at: c.now.Add(d),
+		c:  make(chan time.Time, 1),
+	}
+
+	if d <= 0 {
+		w.c <- c.now
+		c.busy++
+	} else {
+		c.waiters = append(c.waiters, &w)
+	}
+
+	return w.c, func() { c.remove(&w) }
+}
+
+// Begin counts work that was queued outside of the clock.
+func (c *Clock) Begin() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.busy++
+}
+
+// End marks work as handled, whether it was queued with Begin or by a tick or
+// a timer firing.
+func (c *Clock) End() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.end()
+}
+
+// Settle blocks until all the work that was counted is handled.
+func (c *Clock) Settle() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.busy > 0 {
+		c.idle.Wait()
+	}
+}
+
+// end marks work as handled. The caller must hold the lock.
+func (c *Clock) end() {
+	c.busy--
+	if c.busy <= 0 {
+		c.idle.Broadcast()
+	}
+}
+
+// remove stops the specified waiter. A time it delivered that was never
+// received won't be handled, so it no longer counts as work.
+func (c *Clock) remove(w *waiter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-w.c:
+		c.end()
+	default:
+	}
+
+	for i := range c.waiters {
+		if c.waiters[i] == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
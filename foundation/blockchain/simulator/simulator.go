@@ -0,0 +1,363 @@
+// Package simulator runs a network of blockchain nodes inside a single
+// process. The nodes talk to each other through their private API over an
+// in-memory transport where latency, dropped requests and partitions can be
+// injected. Every node runs on the same manual clock and keys are derived
+// from a seed so a run can be reproduced.
+//
+// The clock counts the work the nodes have left, so after Settle returns
+// nothing happens until the clock moves or a transaction is submitted. A
+// scenario is a sequence of those steps, which makes it deterministic.
+//
+// The nodes can't resolve forks. A node only adds blocks on top of its own
+// latest block, so two nodes that both mined while they were partitioned
+// keep their own chains after the partition heals. Syncing from each other
+// fails with an invalid previous hash from then on.
+package simulator
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool/selector"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/peer"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/state"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/worker"
+)
+
+// Config represents the settings for a simulated network.
+type Config struct {
+	Nodes              int                                // Number of nodes in the network.
+	Accounts           int                                // Number of funded accounts to generate for sending transactions.
+	Balance            int64                              // Balance each generated account starts with.
+	Genesis            genesis.Genesis                    // Keep the difficulty low so blocks are mined quickly.
+	Seed               int64                              // Seeds the keys and the network faults.
+	Clock              *Clock                             // Drives the time stamps and the timers of every node. Nil starts a clock at the current time.
+	PrivateMux         func(st *state.State) http.Handler // Serves the private API of a node. Required.
+	MemPoolStrategy    string                             // Defaults to the tip strategy.
+	MiningWorkers      int
+	PeerUpdateInterval time.Duration
+	BlockInterval      time.Duration
+	EvHandler          func(node int, v string, args ...any) // Receives the events of every node. Nil discards them.
+}
+
+// Node represents a single node in the network.
+type Node struct {
+	Index         int
+	Host          string
+	PrivateKey    *ecdsa.PrivateKey
+	BeneficiaryID database.AccountID
+	State         *state.State
+
+	ev  state.EventHandler
+	mux http.Handler
+}
+
+// Network represents a set of nodes connected by an in-memory transport.
+type Network struct {
+	Nodes    []*Node
+	Accounts []*ecdsa.PrivateKey
+	Faults   *Faults
+	Clock    *Clock
+
+	mu    sync.RWMutex
+	muxes map[string]http.Handler
+}
+
+// New constructs a network and starts every node. Every node knows about
+// all the others from the start.
+func New(cfg Config) (*Network, error) {
+	if cfg.Nodes <= 0 {
+		return nil, errors.New("a network needs at least one node")
+	}
+
+	if cfg.PrivateMux == nil {
+		return nil, errors.New("a network needs the private API of the nodes")
+	}
+
+	if cfg.Clock == nil {
+		cfg.Clock = NewClock(time.Now())
+	}
+
+	if cfg.MiningWorkers <= 0 {
+		cfg.MiningWorkers = 1
+	}
+
+	if cfg.MemPoolStrategy == "" {
+		cfg.MemPoolStrategy = selector.StrategyTip
+	}
+
+	if cfg.Balance == 0 {
+		cfg.Balance = 1_000_000
+	}
+
+	n := Network{
+		Faults: newFaults(cfg.Seed),
+		Clock:  cfg.Clock,
+		muxes:  make(map[string]http.Handler),
+	}
+
+	// Copy the genesis balances so the caller's genesis isn't modified when
+	// the generated accounts are funded.
+	gen := cfg.Genesis
+	gen.Balances = make(map[string]int64, len(cfg.Genesis.Balances)+cfg.Accounts)
+	for account, balance := range cfg.Genesis.Balances {
+		gen.Balances[account] = balance
+	}
+
+	for i := 0; i < cfg.Accounts; i++ {
+		key, err := deriveKey(cfg.Seed, "account", i)
+		if err != nil {
+			return nil, err
+		}
+
+		accountID, err := database.PublicKeyToAccountID(key.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		gen.Balances[string(accountID)] = cfg.Balance
+		n.Accounts = append(n.Accounts, key)
+	}
+
+	hosts := make([]string, cfg.Nodes)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("node%d:9080", i)
+	}
+
+	for i, host := range hosts {
+		node, err := n.newNode(cfg, gen, i, hosts)
+		if err != nil {
+			return nil, fmt.Errorf("constructing node %s: %w", host, err)
+		}
+		n.Nodes = append(n.Nodes, node)
+	}
+
+	// A node can only be reached once its worker is running, like a node
+	// that has finished starting up. The nodes started before it find out
+	// about its blocks on their next peer update.
+	for _, node := range n.Nodes {
+		worker.Init(node.State, node.ev, worker.Config{
+			PeerUpdateInterval: cfg.PeerUpdateInterval,
+			MiningWorkers:      cfg.MiningWorkers,
+			BlockInterval:      cfg.BlockInterval,
+			Now:                cfg.Clock.Now,
+			Ticker:             cfg.Clock.NewTicker,
+			Tracker:            cfg.Clock,
+		})
+
+		n.mu.Lock()
+		n.muxes[node.Host] = node.mux
+		n.mu.Unlock()
+	}
+
+	// Every node has synced and announced itself once the network is
+	// settled.
+	n.Clock.Settle()
+
+	return &n, nil
+}
+
+// newNode constructs the state and the private API for a node.
+func (n *Network) newNode(cfg Config, gen genesis.Genesis, index int, hosts []string) (*Node, error) {
+	key, err := deriveKey(cfg.Seed, "node", index)
+	if err != nil {
+		return nil, err
+	}
+
+	beneficiaryID, err := database.PublicKeyToAccountID(key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ev := func(v string, args ...any) {}
+	if cfg.EvHandler != nil {
+		ev = func(v string, args ...any) {
+			cfg.EvHandler(index, v, args...)
+		}
+	}
+
+	peerSet := peer.NewSet()
+	for _, host := range hosts {
+		peerSet.AddSeed(peer.New(host))
+	}
+
+	st, err := state.NewState(state.Config{
+		BeneficiaryID:   beneficiaryID,
+		Host:            hosts[index],
+		KnownPeers:      peerSet,
+		Genesis:         gen,
+		PrivateKey:      key,
+		Storage:         storage.NewMemoryStorage(),
+		EvHandler:       ev,
+		MemPoolStrategy: cfg.MemPoolStrategy,
+		Transport:       &transport{net: n, from: hosts[index]},
+		Now:             cfg.Clock.Now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	node := Node{
+		Index:         index,
+		Host:          hosts[index],
+		PrivateKey:    key,
+		BeneficiaryID: beneficiaryID,
+		State:         st,
+		ev:            ev,
+		mux:           cfg.PrivateMux(st),
+	}
+
+	return &node, nil
+}
+
+// shutdownTimeout limits how long a node has to stop.
+const shutdownTimeout = 10 * time.Second
+
+// Shutdown stops every node in the network.
+func (n *Network) Shutdown() {
+	for _, node := range n.Nodes {
+		n.shutdownNode(node)
+	}
+}
+
+// Stop shuts down a single node and takes it off the network, as if the
+// process had crashed. Requests sent to it fail from then on.
+func (n *Network) Stop(index int) {
+	node := n.Nodes[index]
+
+	n.mu.Lock()
+	delete(n.muxes, node.Host)
+	n.mu.Unlock()
+
+	n.shutdownNode(node)
+}
+
+// shutdownNode stops the node's worker and flushes its state.
+func (n *Network) shutdownNode(node *Node) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	node.State.Shutdown(ctx)
+}
+
+// Settle waits until the nodes handled everything that was sent to them and
+// everything the clock fired, so nothing happens until the clock moves again.
+func (n *Network) Settle() {
+	n.Clock.Settle()
+}
+
+// AdvanceUntil moves the clock forward one step at a time until the condition
+// is true or the clock moved by limit. The network is settled before the
+// condition is checked. It reports whether the condition became true.
+func (n *Network) AdvanceUntil(step time.Duration, limit time.Duration, condition func() bool) bool {
+	for elapsed := time.Duration(0); ; elapsed += step {
+		n.Settle()
+		if condition() {
+			return true
+		}
+
+		if elapsed >= limit {
+			return false
+		}
+
+		n.Clock.Advance(step)
+	}
+}
+
+// Converged reports whether every running node has the same latest block at
+// or past the specified height.
+func (n *Network) Converged(height uint64) bool {
+	var hash string
+
+	for _, node := range n.running() {
+		latest := node.State.GetLastBlock()
+		if latest.Header.Number < height {
+			return false
+		}
+
+		switch {
+		case hash == "":
+			hash = latest.Hash()
+		case hash != latest.Hash():
+			return false
+		}
+	}
+
+	return true
+}
+
+// SubmitTx signs a transfer from one of the generated accounts and submits
+// it to the specified node. The node shares it with its peers once the
+// network is settled.
+func (n *Network) SubmitTx(node int, account int, to database.AccountID, value uint64, tip uint64, nonce uint64) error {
+	key := n.Accounts[account]
+
+	fromID, err := database.PublicKeyToAccountID(key.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	st := n.Nodes[node].State
+	tx, err := database.NewTx(fromID, to, value, tip, st.GetGenesis().ChainID, nil, nonce)
+	if err != nil {
+		return err
+	}
+
+	signedTx, err := tx.Sign(key)
+	if err != nil {
+		return err
+	}
+
+	return st.SubmitTx(signedTx)
+}
+
+// running returns the nodes that haven't been stopped.
+func (n *Network) running() []*Node {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	nodes := make([]*Node, 0, len(n.Nodes))
+	for _, node := range n.Nodes {
+		if _, exists := n.muxes[node.Host]; exists {
+			nodes = append(nodes, node)
+		}
+	}
+
+	return nodes
+}
+
+// =============================================================================
+
+// deriveKey returns a private key derived from the seed so the accounts are
+// the same on every run.
+func deriveKey(seed int64, kind string, index int) (*ecdsa.PrivateKey, error) {
+	data := make([]byte, 16, 16+len(kind))
+	binary.BigEndian.PutUint64(data, uint64(seed))
+	binary.BigEndian.PutUint64(data[8:], uint64(index))
+	data = append(data, kind...)
+
+	for attempt := 0; attempt < 10; attempt++ {
+		hash := sha256.Sum256(data)
+
+		key, err := crypto.ToECDSA(hash[:])
+		if err == nil {
+			return key, nil
+		}
+
+		data = hash[:]
+	}
+
+	return nil, errors.New("unable to derive key")
+}
@@ -0,0 +1,265 @@
+package simulator_test
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ardanlabs/blockchain/app/services/node/handlers"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/peer"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/simulator"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/state"
+)
+
+// to is the account the transfers in the scenarios are sent to.
+const to = database.AccountID("0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76")
+
+func TestPartitionHeals(t *testing.T) {
+	const peerUpdateInterval = time.Minute
+
+	net := newNetwork(t, 3, peerUpdateInterval)
+
+	net.Partition([]int{0, 1}, []int{2})
+
+	mineTx(t, net, 0, 1)
+
+	if h := height(net, 1); h != 1 {
+		t.Fatalf("node 1 didn't receive the block in its partition: height %d", h)
+	}
+
+	if h := height(net, 2); h != 0 {
+		t.Fatalf("node 2 received a block across the partition: height %d", h)
+	}
+
+	// Nothing resends the block after the partition heals, so node 2 only
+	// catches up when its peer update ticker fires on the clock.
+	net.Faults.Heal()
+	net.Settle()
+
+	if h := height(net, 2); h != 0 {
+		t.Fatalf("node 2 caught up before the clock moved: height %d", h)
+	}
+
+	if !net.AdvanceUntil(peerUpdateInterval, 2*peerUpdateInterval, func() bool { return net.Converged(1) }) {
+		t.Fatalf("network didn't converge after healing: heights %d %d %d", height(net, 0), height(net, 1), height(net, 2))
+	}
+
+	checkStateRoots(t, net)
+}
+
+func TestLatency(t *testing.T) {
+	const latency = 5 * time.Second
+
+	net := newNetwork(t, 2, time.Hour)
+
+	net.Faults.SetLatency(latency)
+
+	mineTx(t, net, 0, 1)
+
+	// The block is on its way to node 1 until the clock covers the latency.
+	if h := height(net, 1); h != 0 {
+		t.Fatalf("block arrived before the clock moved: height %d", h)
+	}
+
+	net.Clock.Advance(latency - time.Second)
+	net.Settle()
+
+	if h := height(net, 1); h != 0 {
+		t.Fatalf("block arrived before the latency passed: height %d", h)
+	}
+
+	if !net.AdvanceUntil(time.Second, latency, func() bool { return net.Converged(1) }) {
+		t.Fatalf("network didn't converge: heights %d %d", height(net, 0), height(net, 1))
+	}
+
+	checkStateRoots(t, net)
+}
+
+func TestDrops(t *testing.T) {
+	const peerUpdateInterval = time.Minute
+
+	net := newNetwork(t, 3, peerUpdateInterval)
+
+	// The block is proposed once and every proposal is dropped.
+	net.Faults.SetDropRate(1)
+
+	mineTx(t, net, 0, 1)
+
+	if h1, h2 := height(net, 1), height(net, 2); h1 != 0 || h2 != 0 {
+		t.Fatalf("dropped block arrived: heights %d %d", h1, h2)
+	}
+
+	// With half the requests dropped the peer updates keep retrying until
+	// both the status and the blocks get through.
+	net.Faults.SetDropRate(0.5)
+
+	if !net.AdvanceUntil(peerUpdateInterval, 20*peerUpdateInterval, func() bool { return net.Converged(1) }) {
+		t.Fatalf("network didn't converge: heights %d %d %d", height(net, 0), height(net, 1), height(net, 2))
+	}
+
+	// The nodes are seeds of each other, so failures don't make them
+	// forget about each other.
+	for _, node := range net.Nodes {
+		if peers := len(node.State.KnownExternalPeers()); peers != 2 {
+			t.Errorf("node %d: got %d peers, expected 2", node.Index, peers)
+		}
+	}
+
+	checkStateRoots(t, net)
+}
+
+func TestGossip(t *testing.T) {
+	net := newNetwork(t, 4, time.Hour)
+
+	if err := net.SubmitTx(0, 0, to, 10, 1, 1); err != nil {
+		t.Fatalf("submitting transaction: %s", err)
+	}
+	net.Settle()
+
+	// The transaction reaches every mempool without the clock moving.
+	for _, node := range net.Nodes {
+		if n := node.State.MempoolLength(); n != 1 {
+			t.Fatalf("node %d: got %d transactions in the mempool, expected 1", node.Index, n)
+		}
+	}
+
+	// Any node can mine it and the block reaches every other node.
+	if err := net.Nodes[3].State.ForceMining(); err != nil {
+		t.Fatalf("forcing mining: %s", err)
+	}
+	net.Settle()
+
+	if !net.Converged(1) {
+		t.Fatalf("block didn't reach every node: heights %d %d %d %d", height(net, 0), height(net, 1), height(net, 2), height(net, 3))
+	}
+
+	for _, node := range net.Nodes {
+		if n := node.State.MempoolLength(); n != 0 {
+			t.Errorf("node %d: got %d transactions left in the mempool, expected 0", node.Index, n)
+		}
+	}
+
+	checkStateRoots(t, net)
+}
+
+// TestForkNotResolved documents that forks aren't resolved. The nodes only
+// add blocks on top of their own latest block.
+func TestForkNotResolved(t *testing.T) {
+	const peerUpdateInterval = time.Minute
+
+	net := newNetwork(t, 2, peerUpdateInterval)
+
+	net.Partition([]int{0}, []int{1})
+
+	mineTx(t, net, 0, 1)
+	mineTx(t, net, 0, 2)
+	mineTx(t, net, 1, 1)
+
+	net.Faults.Heal()
+
+	if net.AdvanceUntil(peerUpdateInterval, 5*peerUpdateInterval, func() bool { return net.Converged(1) }) {
+		t.Fatal("network converged after a fork")
+	}
+
+	if h0, h1 := height(net, 0), height(net, 1); h0 != 2 || h1 != 1 {
+		t.Fatalf("got heights %d %d, expected 2 1", h0, h1)
+	}
+
+	// Node 1 keeps trying to sync the longer chain, which fails on the
+	// first block.
+	err := net.Nodes[1].State.NetRequestPeerBlocks(peer.New(net.Nodes[0].Host), 2)
+	if err == nil || !strings.Contains(err.Error(), "Invalid previous hash") {
+		t.Fatalf("syncing the longer chain: got %v, expected an invalid previous hash", err)
+	}
+
+	if peers := len(net.Nodes[1].State.KnownExternalPeers()); peers != 1 {
+		t.Errorf("node 1 forgot about node 0: got %d peers, expected 1", peers)
+	}
+}
+
+// =============================================================================
+
+// newNetwork starts a network on a clock that only moves when the test moves
+// it. The nodes never mine on their own, only when they are forced to.
+func newNetwork(t *testing.T, nodes int, peerUpdateInterval time.Duration) *simulator.Network {
+	t.Helper()
+
+	gen := genesis.Genesis{
+		Date:          time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC),
+		ChainID:       1,
+		TransPerBlock: 10,
+		Difficulty:    1,
+		MiningReward:  700,
+		GasPrice:      15,
+	}
+
+	net, err := simulator.New(simulator.Config{
+		Nodes:              nodes,
+		Accounts:           1,
+		Genesis:            gen,
+		Seed:               1,
+		Clock:              simulator.NewClock(gen.Date),
+		PrivateMux:         privateMux,
+		PeerUpdateInterval: peerUpdateInterval,
+	})
+	if err != nil {
+		t.Fatalf("starting network: %s", err)
+	}
+	t.Cleanup(net.Shutdown)
+
+	return net
+}
+
+// privateMux serves the private API of a node the way the node service does.
+func privateMux(st *state.State) http.Handler {
+	return handlers.PrivateMux(handlers.MuxConfig{
+		Build:    "test",
+		Shutdown: make(chan os.Signal, 1),
+		Log:      zap.NewNop().Sugar(),
+		State:    st,
+	})
+}
+
+// mineTx submits a transfer with the nonce to the node, makes the node mine
+// it and settles the network.
+func mineTx(t *testing.T, net *simulator.Network, node int, nonce uint64) {
+	t.Helper()
+
+	expected := height(net, node) + 1
+
+	if err := net.SubmitTx(node, 0, to, 10, 1, nonce); err != nil {
+		t.Fatalf("submitting transaction: %s", err)
+	}
+
+	if err := net.Nodes[node].State.ForceMining(); err != nil {
+		t.Fatalf("forcing mining: %s", err)
+	}
+	net.Settle()
+
+	if h := height(net, node); h != expected {
+		t.Fatalf("node %d didn't mine the block: height %d, expected %d", node, h, expected)
+	}
+}
+
+// height returns the number of the latest block of the node.
+func height(net *simulator.Network, node int) uint64 {
+	return net.Nodes[node].State.GetLastBlock().Header.Number
+}
+
+// checkStateRoots checks every node applied the blocks to the same state.
+func checkStateRoots(t *testing.T, net *simulator.Network) {
+	t.Helper()
+
+	expected := net.Nodes[0].State.GetStateRoot()
+	for _, node := range net.Nodes[1:] {
+		if root := node.State.GetStateRoot(); root != expected {
+			t.Errorf("node %d: state root %s, expected %s", node.Index, root, expected)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package simulator
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+)
+
+// Set of errors returned by the transport when a request doesn't reach the
+// destination node.
+var (
+	ErrUnreachable = errors.New("node is unreachable")
+	ErrPartitioned = errors.New("nodes are partitioned")
+	ErrDropped     = errors.New("request was dropped")
+)
+
+// transport delivers the requests of one node straight to the handlers of
+// the destination node, applying the network faults on the way.
+type transport struct {
+	net  *Network
+	from string
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	to := req.URL.Host
+
+	latency, err := t.net.Faults.check(t.from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	t.net.mu.RLock()
+	handler, exists := t.net.muxes[to]
+	t.net.mu.RUnlock()
+
+	if !exists {
+		return nil, ErrUnreachable
+	}
+
+	// The latency is measured on the clock of the network, so a request
+	// only arrives once the clock has moved far enough. Requests are only
+	// sent by work the clock counts, and that work can't go on while the
+	// request waits, so it stops counting until the timer fires.
+	if latency > 0 {
+		after, stop := t.net.Clock.After(latency)
+		t.net.Clock.End()
+
+		select {
+		case <-after:
+		case <-req.Context().Done():
+			stop()
+			t.net.Clock.Begin()
+			return nil, req.Context().Err()
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	return rec.Result(), nil
+}
+
+// =============================================================================
+
+// Faults holds the network faults applied to every request between nodes.
+// Drops are decided by a random source for every pair of hosts, seeded from
+// the network seed and the hosts. The requests between two nodes see the
+// same drops on every run, whatever order the nodes send them in.
+type Faults struct {
+	mu        sync.Mutex
+	seed      int64
+	rngs      map[route]*rand.Rand
+	latency   time.Duration
+	dropRate  float64
+	partition map[string]int
+}
+
+// route identifies the direction requests travel between two hosts.
+type route struct {
+	from string
+	to   string
+}
+
+func newFaults(seed int64) *Faults {
+	return &Faults{
+		seed: seed,
+		rngs: make(map[route]*rand.Rand),
+	}
+}
+
+// SetLatency sets how long every request takes to reach its destination.
+func (f *Faults) SetLatency(latency time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.latency = latency
+}
+
+// SetDropRate sets the fraction of requests, between 0 and 1, that never
+// reach their destination.
+func (f *Faults) SetDropRate(rate float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.dropRate = rate
+}
+
+// Heal removes the partition so every node can reach every other node.
+func (f *Faults) Heal() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.partition = nil
+}
+
+// setPartition splits the hosts into groups that can only reach the hosts in
+// the same group. Hosts not in any group can reach everyone.
+func (f *Faults) setPartition(groups [][]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.partition = make(map[string]int)
+	for i, group := range groups {
+		for _, host := range group {
+			f.partition[host] = i
+		}
+	}
+}
+
+// check decides the fate of a request between two hosts.
+func (f *Faults) check(from string, to string) (time.Duration, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.partition != nil {
+		fromGroup, fromExists := f.partition[from]
+		toGroup, toExists := f.partition[to]
+
+		if fromExists && toExists && fromGroup != toGroup {
+			return 0, ErrPartitioned
+		}
+	}
+
+	if f.dropRate > 0 && f.rand(from, to).Float64() < f.dropRate {
+		return 0, ErrDropped
+	}
+
+	return f.latency, nil
+}
+
+// rand returns the random source for the requests between the hosts. The
+// caller must hold the lock.
+func (f *Faults) rand(from string, to string) *rand.Rand {
+	r := route{from: from, to: to}
+
+	rng, exists := f.rngs[r]
+	if !exists {
+		hash := fnv.New64a()
+		hash.Write([]byte(from + ">" + to))
+		rng = rand.New(rand.NewSource(f.seed ^ int64(hash.Sum64())))
+		f.rngs[r] = rng
+	}
+
+	return rng
+}
+
+// =============================================================================
+
+// Partition splits the network into groups of nodes, by index, that can only
+// talk to the nodes in the same group. Call Faults.Heal to join them again.
+func (n *Network) Partition(groups ...[]int) {
+	hosts := make([][]string, len(groups))
+	for i, group := range groups {
+		for _, index := range group {
+			hosts[i] = append(hosts[i], n.Nodes[index].Host)
+		}
+	}
+
+	n.Faults.setPartition(hosts)
+}
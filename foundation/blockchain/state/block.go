@@ -6,10 +6,16 @@ import (
 	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
 )
 
+// ValidateBlock checks the block can be added on top of the latest block.
 func (s *State) ValidateBlock(block *database.Block) error {
 	s.Mu.RLock()
 	defer s.Mu.RUnlock()
 
+	return s.validateBlock(block)
+}
+
+// validateBlock performs the block validation. The caller must hold the lock.
+func (s *State) validateBlock(block *database.Block) error {
 	lastBlock := s.GetLastBlock()
 
 	if block.Header.Number != lastBlock.Header.Number+1 {
@@ -20,41 +26,152 @@ func (s *State) ValidateBlock(block *database.Block) error {
 		return errors.New("Invalid previous hash")
 	}
 
-	if block.MerkleTree.Verify() != nil && block.MerkleTree.RootHex() != block.Header.TransRoot {
-		return errors.New("Transaction hashes and TransRoot Does not match")
+	if err := block.MerkleTree.Verify(); err != nil {
+		return errors.Wrap(err, "Transaction hashes can't be verified")
 	}
 
-	if block.Header.Difficulty < lastBlock.Header.Difficulty {
-		return errors.New("Difficulty level can't be lower, that in previous block")
+	if block.MerkleTree.RootHex() != block.Header.TransRoot {
+		return errors.New("Transaction hashes and TransRoot Does not match")
 	}
 
-	if !database.IsHashSolved(block.Header.Difficulty, block.Hash()) {
-		return errors.New("Hash is not solved")
+	// The producer of the block can't be trusted with the transactions of
+	// other accounts, so every signature is checked before any is applied.
+	// Every transaction must also carry the nonce after the last one its
+	// account used, so a mined transaction can't be included again.
+	nonces := make(map[database.AccountID]uint64)
+	for _, tx := range block.MerkleTree.Values() {
+		if err := tx.IsValid(); err != nil {
+			return errors.Wrapf(err, "Invalid transaction %s", tx)
+		}
+
+		if tx.ChainId != s.Genesis.ChainID {
+			return errors.Errorf("Invalid transaction %s: wrong chain id, got %d, expected %d", tx, tx.ChainId, s.Genesis.ChainID)
+		}
+
+		nonce, err := s.nextNonce(nonces, tx.FromID)
+		if err != nil {
+			return err
+		}
+
+		if tx.Nonce != nonce {
+			return errors.Errorf("Invalid transaction %s: wrong nonce, got %d, expected %d", tx, tx.Nonce, nonce)
+		}
+		nonces[tx.FromID] = tx.Nonce
 	}
 
 	if block.Header.TimeStamp < lastBlock.Header.TimeStamp {
 		return errors.New("Wrong time")
 	}
 
+	if block.Header.StateRoot != s.Db.GetStateRoot() {
+		return errors.New("State root does not match")
+	}
+
+	if err := s.Consensus.Verify(*block, lastBlock); err != nil {
+		return errors.Wrapf(err, "Block fails %s consensus", s.Consensus.Name())
+	}
+
 	return nil
 }
 
+// UpdateBlock validates the block, applies its transactions to the state
+// and writes it to storage as the new latest block.
 func (s *State) UpdateBlock(block *database.Block) error {
 	s.Mu.Lock()
 	defer s.Mu.Unlock()
 
-	if err := s.ValidateBlock(block); err != nil {
+	if err := s.validateBlock(block); err != nil {
 		return errors.Wrap(err, "Error while validating block")
 	}
 
+	// The state only changes once the block is stored, so the mempool is
+	// left alone until then.
+	if err := s.Db.ApplyBlock(*block); err != nil {
+		return errors.Wrap(err, "Error while saving block")
+	}
+
 	for _, tx := range block.MerkleTree.Values() {
-		err := s.Db.ApplyTransaction(tx, block.Header.BeneficiaryID)
-		if err != nil {
-			return errors.Wrap(err, "Error while applying transaction")
-		}
 		s.memPool.Remove(tx)
 	}
 
-	s.Db.ApplyMiningReward(block.Header.BeneficiaryID)
+	s.pruneEvidence()
+
+	// The block is in the chain even when pruning fails. Pruning is tried
+	// again with the next block.
+	if s.pruneDepth > 0 {
+		if err := s.Db.Prune(s.pruneDepth); err != nil {
+			s.EvHandler("state: UpdateBlock: Prune: ERROR: %s", err)
+		}
+	}
+
+	// Any block being mined on top of the old tip is now stale.
+	if s.Worker != nil {
+		s.Worker.SignalTipChanged()
+	}
+
+	s.EvHandler("viewer: state: UpdateBlock: blk[%d]: hash[%s]", block.Header.Number, block.Hash())
+
 	return nil
 }
+
+// ProcessProposedBlock takes a block mined by a peer, validates it and adds
+// it to the chain. The worker is told the tip changed, so any mining
+// operation in progress is abandoned and rebuilt on top of this block with
+// the transactions that are left in the mempool.
+func (s *State) ProcessProposedBlock(block database.Block) error {
+	s.EvHandler("state: ProcessProposedBlock: started: prevBlk[%s]: newBlk[%s]: numTrans[%d]", block.Header.PrevBlockHash, block.Hash(), len(block.MerkleTree.Values()))
+	defer s.EvHandler("state: ProcessProposedBlock: completed: newBlk[%s]", block.Hash())
+
+	if s.isShuttingDown() {
+		return ErrShuttingDown
+	}
+
+	if err := s.UpdateBlock(&block); err != nil {
+
+		// A block for a height this node already has may be proof that
+		// its producer signed two blocks.
+		s.checkEquivocation(block)
+		return err
+	}
+
+	return nil
+}
+
+// BlockTemplate returns what the next block is built from: the latest block,
+// the state root after it and the best transactions in the mempool. They are
+// read under the same lock so they can't come from different tips.
+func (s *State) BlockTemplate(howMany uint16) (database.Block, string, []database.BlockTx) {
+	s.Mu.RLock()
+	defer s.Mu.RUnlock()
+
+	// A transaction that doesn't follow the nonce its account is at waits in
+	// the mempool for the transactions before it, since the block would be
+	// rejected otherwise.
+	var trans []database.BlockTx
+	nonces := make(map[database.AccountID]uint64)
+	for _, tx := range s.memPool.PickBest(howMany) {
+		if nonce, err := s.nextNonce(nonces, tx.FromID); err != nil || tx.Nonce != nonce {
+			continue
+		}
+		nonces[tx.FromID] = tx.Nonce
+		trans = append(trans, tx)
+	}
+
+	return s.GetLastBlock(), s.Db.GetStateRoot(), trans
+}
+
+// nextNonce returns the nonce the next transaction from the account must
+// carry. The nonces map holds the last nonce of the accounts that already
+// have transactions in the block being checked or built.
+func (s *State) nextNonce(nonces map[database.AccountID]uint64, accountID database.AccountID) (uint64, error) {
+	if nonce, exists := nonces[accountID]; exists {
+		return nonce + 1, nil
+	}
+
+	account, err := s.Db.Query(accountID)
+	if err != nil && !errors.Is(err, database.NotFound) {
+		return 0, errors.Wrap(err, "Error while querying account")
+	}
+
+	return account.Nonce + 1, nil
+}
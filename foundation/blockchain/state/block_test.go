@@ -0,0 +1,177 @@
+package state_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool/selector"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/state"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+)
+
+// to is the account the transfers in the tests are sent to.
+const to = database.AccountID("0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76")
+
+func TestProposedBlockNonces(t *testing.T) {
+	tests := []struct {
+		name   string
+		nonces []uint64 // Nonces of the transactions in the block after the first one.
+		valid  bool
+	}{
+		{name: "next nonce", nonces: []uint64{2}, valid: true},
+		{name: "several in order", nonces: []uint64{2, 3, 4}, valid: true},
+		{name: "replayed transaction", nonces: []uint64{1}},
+		{name: "gap", nonces: []uint64{3}},
+		{name: "out of order", nonces: []uint64{3, 2}},
+		{name: "repeated in the block", nonces: []uint64{2, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, key := newState(t)
+
+			// The first block mines the transaction with nonce 1.
+			first := newTx(t, key, 1, 10)
+			if err := st.ProcessProposedBlock(mineBlock(t, st, first)); err != nil {
+				t.Fatalf("first block rejected: %s", err)
+			}
+
+			var trans []database.BlockTx
+			for i, nonce := range tt.nonces {
+				tx := newTx(t, key, nonce, 10+uint64(i))
+				if nonce == 1 {
+					tx = first
+				}
+				trans = append(trans, tx)
+			}
+
+			err := st.ProcessProposedBlock(mineBlock(t, st, trans...))
+
+			switch {
+			case tt.valid && err != nil:
+				t.Errorf("block rejected: %s", err)
+			case !tt.valid && err == nil:
+				t.Error("block accepted")
+			}
+
+			expectedHeight := uint64(1)
+			paid := []database.BlockTx{first}
+			if tt.valid {
+				expectedHeight = 2
+				paid = append(paid, trans...)
+			}
+
+			if got := st.GetLastBlock().Header.Number; got != expectedHeight {
+				t.Errorf("latest block: got %d, expected %d", got, expectedHeight)
+			}
+
+			// The sender must pay for every transaction exactly once.
+			expectedBalance := int64(1_000_000)
+			for _, tx := range paid {
+				expectedBalance -= int64(tx.Value + tx.Tip + tx.GasPrice*tx.GasUnits)
+			}
+
+			account, err := st.Query(first.FromID)
+			if err != nil {
+				t.Fatalf("querying sender: %s", err)
+			}
+			if account.Balance != expectedBalance {
+				t.Errorf("sender balance: got %d, expected %d", account.Balance, expectedBalance)
+			}
+		})
+	}
+}
+
+// =============================================================================
+
+// newState constructs a proof of work node with a single funded account and
+// no worker, so blocks are only added by the test.
+func newState(t *testing.T) (*state.State, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := crypto.HexToECDSA("fae85851bdf5c9f49923722ce38f3c1defcfd3619ef5453230a58ad805499959")
+	if err != nil {
+		t.Fatalf("loading key: %s", err)
+	}
+
+	from, err := database.PublicKeyToAccountID(key.PublicKey)
+	if err != nil {
+		t.Fatalf("converting key: %s", err)
+	}
+
+	gen := genesis.Genesis{
+		Date:          time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC),
+		ChainID:       1,
+		TransPerBlock: 10,
+		Difficulty:    1,
+		MiningReward:  700,
+		GasPrice:      15,
+		Balances:      map[string]int64{string(from): 1_000_000},
+	}
+
+	st, err := state.NewState(state.Config{
+		BeneficiaryID:   "0xFef311483Cc040e1A89fb9bb469eeB8A70935EF8",
+		Genesis:         gen,
+		PrivateKey:      key,
+		Storage:         storage.NewMemoryStorage(),
+		MemPoolStrategy: selector.StrategyTip,
+	})
+	if err != nil {
+		t.Fatalf("constructing state: %s", err)
+	}
+
+	return st, key
+}
+
+// newTx signs a transfer from the key with the specified nonce.
+func newTx(t *testing.T, key *ecdsa.PrivateKey, nonce uint64, value uint64) database.BlockTx {
+	t.Helper()
+
+	from, err := database.PublicKeyToAccountID(key.PublicKey)
+	if err != nil {
+		t.Fatalf("converting key: %s", err)
+	}
+
+	tx, err := database.NewTx(from, to, value, 1, 1, nil, nonce)
+	if err != nil {
+		t.Fatalf("constructing transaction: %s", err)
+	}
+
+	signedTx, err := tx.Sign(key)
+	if err != nil {
+		t.Fatalf("signing transaction: %s", err)
+	}
+
+	return database.NewBlockTx(signedTx, 15, 1)
+}
+
+// mineBlock mines the next block of the node with the transactions, the way
+// a peer would before proposing it.
+func mineBlock(t *testing.T, st *state.State, trans ...database.BlockTx) database.Block {
+	t.Helper()
+
+	latest := st.GetLastBlock()
+
+	block, err := database.POW(context.Background(), database.POWArgs{
+		BeneficiaryID: "0xFef311483Cc040e1A89fb9bb469eeB8A70935EF8",
+		Difficulty:    st.GetGenesis().Difficulty,
+		MiningReward:  uint64(st.GetGenesis().MiningReward),
+		PrevBlock:     latest,
+		StateRoot:     st.GetStateRoot(),
+		Trans:         trans,
+		Workers:       1,
+		TimeStamp:     latest.Header.TimeStamp + 1,
+		EvHandler:     func(v string, args ...any) {},
+	})
+	if err != nil {
+		t.Fatalf("mining block: %s", err)
+	}
+
+	return block
+}
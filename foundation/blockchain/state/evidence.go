@@ -0,0 +1,81 @@
+package state
+
+import (
+	"github.com/ardanlabs/blockchain/foundation/blockchain/consensus"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+)
+
+// PendingEvidence returns the equivocations this node has seen that haven't
+// been included in a block yet.
+func (s *State) PendingEvidence() []database.Equivocation {
+	s.Mu.RLock()
+	defer s.Mu.RUnlock()
+
+	evidence := make([]database.Equivocation, len(s.evidence))
+	copy(evidence, s.evidence)
+
+	return evidence
+}
+
+// checkEquivocation compares a block proposed by a peer with the block this
+// node has at the same height. If the same validator signed both, the
+// evidence is kept so the next block this node produces gets it slashed.
+func (s *State) checkEquivocation(block database.Block) {
+	if s.Consensus.Name() != consensus.POS {
+		return
+	}
+
+	if block.Header.Number == 0 || block.Header.Number > s.GetLastBlock().Header.Number {
+		return
+	}
+
+	ours, err := s.Db.GetHeader(block.Header.Number)
+	if err != nil {
+		return
+	}
+
+	eq := database.Equivocation{
+		First:  ours,
+		Second: block.Header,
+	}
+
+	offender, err := eq.Offender()
+	if err != nil {
+		return
+	}
+
+	if s.Db.Stakes()[offender] == 0 {
+		return
+	}
+
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	for _, pending := range s.evidence {
+		if o, _ := pending.Offender(); o == offender {
+			return
+		}
+	}
+
+	s.evidence = append(s.evidence, eq)
+	s.EvHandler("viewer: state: checkEquivocation: EQUIVOCATION: account[%s]: blk[%d]", offender, block.Header.Number)
+}
+
+// pruneEvidence drops the evidence for validators that have no stake left,
+// which includes the ones slashed by the latest block. The caller must hold
+// the write lock.
+func (s *State) pruneEvidence() {
+	if len(s.evidence) == 0 {
+		return
+	}
+
+	stakes := s.Db.Stakes()
+
+	evidence := s.evidence[:0]
+	for _, eq := range s.evidence {
+		if offender, err := eq.Offender(); err == nil && stakes[offender] > 0 {
+			evidence = append(evidence, eq)
+		}
+	}
+	s.evidence = evidence
+}
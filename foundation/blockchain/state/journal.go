@@ -0,0 +1,87 @@
+package state
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"emperror.dev/errors"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+)
+
+// loadJournal restores the transactions saved to the journal when the node
+// last shut down. Transactions that are no longer valid against the state,
+// because they were mined in the meantime for example, are dropped.
+func (s *State) loadJournal() error {
+	if s.journal == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.journal)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return errors.Wrap(err, "Error while reading journal")
+	}
+
+	var txs []database.BlockTx
+	if err := json.Unmarshal(data, &txs); err != nil {
+		return errors.Wrap(err, "Error while decoding journal")
+	}
+
+	var restored int
+	for _, tx := range txs {
+		if err := tx.IsValid(); err != nil {
+			continue
+		}
+
+		if err := s.validateTx(tx); err != nil {
+			s.EvHandler("state: loadJournal: tx[%s]: DROPPED: %s", tx, err)
+			continue
+		}
+
+		if err := s.memPool.Upsert(tx); err != nil {
+			s.EvHandler("state: loadJournal: tx[%s]: DROPPED: %s", tx, err)
+			continue
+		}
+
+		s.seen.add(tx.TxHash())
+		restored++
+	}
+
+	s.EvHandler("state: loadJournal: restored[%d]: journal[%d]", restored, len(txs))
+
+	return nil
+}
+
+// saveJournal writes the mempool to the journal. The file is replaced with a
+// rename so a crash while writing leaves the previous journal in place.
+func (s *State) saveJournal() error {
+	if s.journal == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(s.memPool.PickBest())
+	if err != nil {
+		return errors.Wrap(err, "Error while encoding journal")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.journal), 0755); err != nil {
+		return errors.Wrap(err, "Error while creating journal directory")
+	}
+
+	tmp := s.journal + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return errors.Wrap(err, "Error while writing journal")
+	}
+
+	if err := os.Rename(tmp, s.journal); err != nil {
+		return errors.Wrap(err, "Error while replacing journal")
+	}
+
+	s.EvHandler("state: saveJournal: saved[%d]", s.memPool.Count())
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"emperror.dev/errors"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/peer"
+)
+
+// blocksPerRequest limits how many blocks are asked from a peer at a time.
+const blocksPerRequest = 100
+
+// NetRequestPeerStatus asks the specified peer for its latest block.
+func (s *State) NetRequestPeerStatus(pr peer.Peer) (peer.Status, error) {
+	s.EvHandler("state: NetRequestPeerStatus: started: %s", pr.Host)
+	defer s.EvHandler("state: NetRequestPeerStatus: completed: %s", pr.Host)
+
+	url := fmt.Sprintf("%s/node/status", fmt.Sprintf(baseURL, pr.Host))
+
+	var ps peer.Status
+	if err := s.send(http.MethodGet, url, nil, &ps); err != nil {
+		return peer.Status{}, err
+	}
+
+	if ps.GenesisHash != s.Genesis.Hash() {
+		return peer.Status{}, fmt.Errorf("peer is on a different chain, genesis[%s]", ps.GenesisHash)
+	}
+
+	s.EvHandler("state: NetRequestPeerStatus: peer-node[%s]: latest-blknum[%d]", pr.Host, ps.LatestBlockNumber)
+
+	return ps, nil
+}
+
+// NetRequestPeerBlocks downloads the blocks the node is missing from the
+// specified peer, in order, and validates and applies each one.
+func (s *State) NetRequestPeerBlocks(pr peer.Peer, to uint64) error {
+	s.EvHandler("state: NetRequestPeerBlocks: started: %s", pr.Host)
+	defer s.EvHandler("state: NetRequestPeerBlocks: completed: %s", pr.Host)
+
+	for {
+		from := s.GetLastBlock().Header.Number + 1
+		if from > to {
+			return nil
+		}
+
+		last := from + blocksPerRequest - 1
+		if last > to {
+			last = to
+		}
+
+		url := fmt.Sprintf("%s/node/block/list/%d/%d", fmt.Sprintf(baseURL, pr.Host), from, last)
+
+		var blocksData []database.BlockData
+		if err := s.send(http.MethodGet, url, nil, &blocksData); err != nil {
+			return err
+		}
+
+		if len(blocksData) == 0 {
+			return fmt.Errorf("peer %s returned no blocks from %d", pr.Host, from)
+		}
+
+		for _, blockData := range blocksData {
+			block, err := database.ToBlock(blockData)
+			if err != nil {
+				return errors.Wrap(err, "Error while converting block")
+			}
+
+			s.EvHandler("state: NetRequestPeerBlocks: prevBlk[%s]: newBlk[%s]: numTrans[%d]", block.Header.PrevBlockHash, block.Hash(), len(blockData.Trans))
+
+			// The peer is trusted no more than a block proposed to this
+			// node, so every block gets the full validation, signatures
+			// included.
+			if err := s.UpdateBlock(&block); err != nil {
+				return errors.Wrapf(err, "Error while applying block %d", block.Header.Number)
+			}
+		}
+	}
+}
+
+// NetSendNodeAvailableToPeers tells the specified peers about this node so
+// they add it to their peer sets.
+func (s *State) NetSendNodeAvailableToPeers(peers []peer.Peer) {
+	s.EvHandler("state: NetSendNodeAvailableToPeers: started")
+	defer s.EvHandler("state: NetSendNodeAvailableToPeers: completed")
+
+	host := peer.New(s.Host)
+
+	for _, pr := range peers {
+		url := fmt.Sprintf("%s/node/peers", fmt.Sprintf(baseURL, pr.Host))
+
+		if err := s.send(http.MethodPost, url, host, nil); err != nil {
+			s.EvHandler("state: NetSendNodeAvailableToPeers: peer[%s]: ERROR: %s", pr.Host, err)
+		}
+	}
+}
+
+// NetSendTxToPeers shares a new mempool transaction with the known peers.
+func (s *State) NetSendTxToPeers(tx database.BlockTx) {
+	s.EvHandler("state: NetSendTxToPeers: started: tx[%s]", tx)
+	defer s.EvHandler("state: NetSendTxToPeers: completed: tx[%s]", tx)
+
+	for _, pr := range s.KnownExternalPeers() {
+		url := fmt.Sprintf("%s/node/tx/submit", fmt.Sprintf(baseURL, pr.Host))
+
+		if err := s.send(http.MethodPost, url, tx, nil); err != nil {
+			s.EvHandler("state: NetSendTxToPeers: peer[%s]: ERROR: %s", pr.Host, err)
+		}
+	}
+}
+
+// NetSendBlockToPeers proposes a block this node mined to the known peers.
+func (s *State) NetSendBlockToPeers(block database.Block) {
+	s.EvHandler("state: NetSendBlockToPeers: started: blk[%s]", block.Hash())
+	defer s.EvHandler("state: NetSendBlockToPeers: completed: blk[%s]", block.Hash())
+
+	blockData := database.NewBlockData(block)
+
+	for _, pr := range s.KnownExternalPeers() {
+		url := fmt.Sprintf("%s/node/block/propose", fmt.Sprintf(baseURL, pr.Host))
+
+		if err := s.send(http.MethodPost, url, blockData, nil); err != nil {
+			s.EvHandler("state: NetSendBlockToPeers: peer[%s]: ERROR: %s", pr.Host, err)
+		}
+	}
+}
+
+// =============================================================================
+
+// baseURL represents the base URL for the private API of a peer.
+const baseURL = "http://%s/v1"
+
+// clientTimeout limits the node to node calls so a peer that stops
+// responding can't hold up the caller forever.
+const clientTimeout = 10 * time.Second
+
+// send is a helper function to send an HTTP request to a node.
+func (s *State) send(method string, url string, dataSend any, dataRecv any) error {
+	var req *http.Request
+
+	switch {
+	case dataSend != nil:
+		data, err := json.Marshal(dataSend)
+		if err != nil {
+			return err
+		}
+		req, err = http.NewRequest(method, url, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+
+	default:
+		var err error
+		req, err = http.NewRequest(method, url, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		msg, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+
+	if dataRecv != nil {
+		if err := json.NewDecoder(resp.Body).Decode(dataRecv); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package state
+
+import "sync"
+
+// seenCapacity is the number of transaction hashes remembered by the node.
+// It only needs to cover the time a transaction takes to spread through the
+// network.
+const seenCapacity = 10_000
+
+// seenTxs remembers the hashes of the transactions the node has accepted so
+// a transaction shared by a peer isn't processed and shared again. The
+// oldest hash is forgotten once the capacity is reached.
+type seenTxs struct {
+	mu     sync.Mutex
+	hashes map[string]struct{}
+	order  []string
+	next   int
+}
+
+func newSeenTxs() *seenTxs {
+	return &seenTxs{
+		hashes: make(map[string]struct{}, seenCapacity),
+		order:  make([]string, 0, seenCapacity),
+	}
+}
+
+// has reports whether the hash has been seen.
+func (st *seenTxs) has(hash string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	_, exists := st.hashes[hash]
+	return exists
+}
+
+// add records the hash, forgetting the oldest one when the cache is full.
+func (st *seenTxs) add(hash string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if _, exists := st.hashes[hash]; exists {
+		return
+	}
+
+	if len(st.order) < seenCapacity {
+		st.order = append(st.order, hash)
+	} else {
+		delete(st.hashes, st.order[st.next])
+		st.order[st.next] = hash
+		st.next = (st.next + 1) % seenCapacity
+	}
+
+	st.hashes[hash] = struct{}{}
+}
@@ -1,13 +1,22 @@
 package state
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"emperror.dev/errors"
 
+	"github.com/ardanlabs/blockchain/foundation/blockchain/consensus"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/genesis"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/mempool/selector"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/peer"
 )
 
 // Config represents the configuration required to start
@@ -15,9 +24,53 @@ import (
 
 type Config struct {
 	BeneficiaryID   database.AccountID // Аккаунт, который получает вохзнограждеение за майнинг или ГАЗ
+	Host            string             // Host of this node's private API, used to tell it apart from its peers.
+	KnownPeers      *peer.Set
 	Genesis         genesis.Genesis
+	PrivateKey      *ecdsa.PrivateKey // Signs the blocks this node produces when genesis selects POA or POS.
+	Storage         database.Storage
 	EvHandler       EventHandler
 	MemPoolStrategy string
+	MinTip          uint64            // Smallest tip a transaction must carry to be accepted into the mempool.
+	BlockLimits     selector.Limits   // Gas and bytes a mined block can hold, for the strategies that consider them.
+	MempoolJournal  string            // File the mempool is saved to on shutdown and restored from on startup. Empty disables it.
+	Transport       http.RoundTripper // Carries the calls to other nodes. Nil uses the default HTTP transport.
+	PruneDepth      uint64            // Blocks that keep their transactions when pruning. Zero keeps every block.
+	Now             func() time.Time  // Clock the block time stamps are checked against. Nil uses the system clock.
+}
+
+// Set of errors returned when a submitted transaction fails the admission
+// checks against the current state.
+var (
+	ErrInvalidTx         = errors.New("invalid transaction")
+	ErrNonceUsed         = errors.New("nonce already used")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrTipTooLow         = errors.New("tip is below the minimum")
+)
+
+// ErrShuttingDown is returned when the node no longer accepts transactions or
+// blocks because it's shutting down.
+var ErrShuttingDown = errors.New("node is shutting down")
+
+// Set of errors returned when a block can't be forced.
+var (
+	ErrMiningPaused  = errors.New("mining is paused")
+	ErrNothingToMine = errors.New("no transactions to mine")
+)
+
+// IsTxRejected reports whether the error means the transaction was rejected
+// by the admission checks, as opposed to a failure inside the node.
+func IsTxRejected(err error) bool {
+	switch {
+	case errors.Is(err, ErrInvalidTx),
+		errors.Is(err, ErrNonceUsed),
+		errors.Is(err, ErrInsufficientFunds),
+		errors.Is(err, ErrTipTooLow),
+		errors.Is(err, mempool.ErrReplaceUnderpriced):
+		return true
+	}
+
+	return false
 }
 
 // Worker interface represents the behavior required to be implemented by any
@@ -25,8 +78,13 @@ type Config struct {
 type Worker interface {
 	Shutdown()
 	Sync()
+	IsMining() bool
+	IsPaused() bool
+	PauseMining()
+	ResumeMining()
 	SignalStartMining()
 	SignalCancelMining()
+	SignalTipChanged()
 	SignalShareTx(blockTx database.BlockTx)
 }
 
@@ -40,11 +98,23 @@ type State struct {
 	Mu sync.RWMutex
 
 	BeneficiaryID database.AccountID
+	Host          string
 	EvHandler     EventHandler
+	MinTip        uint64
+	synced        bool
+	journal       string
+	client        *http.Client
+	shuttingDown  int32
+	shutdownOnce  sync.Once
+	pruneDepth    uint64
 
-	Genesis genesis.Genesis
-	Db      *database.Database
-	memPool *mempool.MemPool
+	KnownPeers *peer.Set
+	Genesis    genesis.Genesis
+	Consensus  consensus.Engine
+	Db         *database.Database
+	memPool    *mempool.MemPool
+	seen       *seenTxs
+	evidence   []database.Equivocation
 
 	Worker Worker
 }
@@ -58,34 +128,117 @@ func NewState(cfg Config) (*State, error) {
 
 	}
 
+	if cfg.PruneDepth > 0 {
+		if _, ok := cfg.Storage.(database.Pruner); !ok {
+			return nil, errors.New("Storage doesn't support pruning")
+		}
+	}
+
 	db, err := database.NewDatabase(
 		cfg.Genesis,
+		cfg.Storage,
 		ev,
 	)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error while creating new database")
 	}
 
-	pool, err := mempool.NewWithStrategy(cfg.MemPoolStrategy)
+	engine, err := consensus.New(consensus.Config{
+		Genesis:    cfg.Genesis,
+		PrivateKey: cfg.PrivateKey,
+		Stakes:     db.Stakes,
+		Now:        cfg.Now,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while creating consensus engine")
+	}
+
+	pool, err := mempool.NewWithLimits(cfg.MemPoolStrategy, cfg.BlockLimits)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error while creating new mempool")
 	}
 
-	return &State{
+	s := State{
 		BeneficiaryID: cfg.BeneficiaryID,
+		Host:          cfg.Host,
 		EvHandler:     ev,
+		MinTip:        cfg.MinTip,
+		KnownPeers:    cfg.KnownPeers,
 		Genesis:       cfg.Genesis,
+		Consensus:     engine,
 		Db:            db,
 		memPool:       pool,
-	}, nil
+		seen:          newSeenTxs(),
+		journal:       cfg.MempoolJournal,
+		pruneDepth:    cfg.PruneDepth,
+		client: &http.Client{
+			Timeout:   clientTimeout,
+			Transport: cfg.Transport,
+		},
+	}
+
+	if err := s.loadJournal(); err != nil {
+		return nil, errors.Wrap(err, "Error while restoring mempool")
+	}
+
+	return &s, nil
+}
+
+// Shutdown stops the node in an order that leaves the chain consistent on
+// disk. New transactions and proposed blocks are refused first. Then the
+// worker cancels any mining operation and finishes the block being
+// committed, if any. Last the storage is flushed and the mempool is saved
+// to the journal. Calling it more than once is safe.
+func (s *State) Shutdown(ctx context.Context) error {
+	var err error
+	s.shutdownOnce.Do(func() {
+		err = s.shutdown(ctx)
+	})
+
+	return err
 }
 
-func (s *State) Shutdown() error {
-	s.EvHandler("Shutting down the state")
+func (s *State) shutdown(ctx context.Context) error {
+	s.EvHandler("state: Shutdown: started")
+	defer s.EvHandler("state: Shutdown: completed")
+
+	atomic.StoreInt32(&s.shuttingDown, 1)
+
+	if s.Worker != nil {
+		done := make(chan struct{})
+		go func() {
+			s.Worker.Shutdown()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "Worker didn't stop in time")
+		}
+	}
+
+	// Taking the lock makes sure no block is in the middle of being applied
+	// while the storage is flushed.
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	if err := s.Db.Close(); err != nil {
+		return errors.Wrap(err, "Error while closing database")
+	}
+
+	if err := s.saveJournal(); err != nil {
+		return errors.Wrap(err, "Error while saving mempool")
+	}
 
 	return nil
 }
 
+// isShuttingDown reports whether Shutdown was called.
+func (s *State) isShuttingDown() bool {
+	return atomic.LoadInt32(&s.shuttingDown) == 1
+}
+
 func (s *State) GetGenesis() genesis.Genesis {
 	return s.Genesis
 }
@@ -100,43 +253,201 @@ func (s *State) Mempool() []database.BlockTx {
 	return s.memPool.PickBest()
 }
 
-// Accepting transaction
+// PickBestTxs returns the best transactions from the mempool to put in the
+// next block using the configured select strategy.
+func (s *State) PickBestTxs(howMany uint16) []database.BlockTx {
+	return s.memPool.PickBest(howMany)
+}
+
+// MempoolForAccount returns the pending transactions for the specified
+// account ordered by nonce.
+func (s *State) MempoolForAccount(accountID database.AccountID) []database.BlockTx {
+	return s.memPool.ForAccount(accountID)
+}
+
+// MempoolTx returns the pending transaction with the specified canonical
+// transaction hash.
+func (s *State) MempoolTx(hash string) (database.BlockTx, bool) {
+	return s.memPool.FindByHash(hash)
+}
+
+// SubmitTx accepts a transaction from a wallet into the mempool after
+// checking it against the current state and the sender's other pending
+// transactions.
 func (s *State) SubmitTx(tx database.SignedTx) error {
-	// CORE NOTE: It's up to the wallet to make sure the account has a proper
-	// balance and this transaction has a proper nonce. Fees will be taken if
-	// this transaction is mined into a block it doesn't have enough money to
-	// pay or the nonce isn't the next expected nonce for the account.
+	const oneUnitOfGas = 1
+	blockTx := database.NewBlockTx(tx, s.Genesis.GasPrice, oneUnitOfGas)
+
+	return s.acceptTx(blockTx)
+}
+
+// SubmitNodeTx accepts a transaction shared by a peer. A transaction this
+// node has already accepted is ignored so it doesn't echo between peers.
+// The gas values are set by this node and not trusted from the peer.
+func (s *State) SubmitNodeTx(tx database.BlockTx) error {
+	if s.seen.has(tx.TxHash()) {
+		return nil
+	}
+
+	const oneUnitOfGas = 1
+	blockTx := database.NewBlockTx(tx.SignedTx, s.Genesis.GasPrice, oneUnitOfGas)
+	blockTx.TimeStamp = tx.TimeStamp
+
+	return s.acceptTx(blockTx)
+}
+
+// acceptTx performs the admission checks, adds the transaction to the
+// mempool and signals it to be shared with the peers.
+func (s *State) acceptTx(blockTx database.BlockTx) error {
+	if s.isShuttingDown() {
+		return ErrShuttingDown
+	}
 
 	// Check the signed transaction has a proper signature, the from matches the
 	// signature, and the from and to fields are properly formatted.
-	if err := tx.IsValid(); err != nil {
-		return errors.Wrap(err, "Invalid transaction")
+	if err := blockTx.IsValid(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidTx, err)
 	}
 
-	const oneUnitOfGas = 1
-	blockTx := database.NewBlockTx(tx, s.Genesis.GasPrice, oneUnitOfGas)
-	if err := s.memPool.Upsert(blockTx); err != nil {
-		return err
+	if blockTx.ChainId != s.Genesis.ChainID {
+		return fmt.Errorf("%w: wrong chain id, got %d, expected %d", ErrInvalidTx, blockTx.ChainId, s.Genesis.ChainID)
 	}
 
+	// The lock keeps two transactions from the same account from passing the
+	// funds check against the same pending set.
 	s.Mu.Lock()
 	defer s.Mu.Unlock()
 
-	if s.MempoolLength() >= int64(s.Genesis.TransPerBlock) {
+	if err := s.validateTx(blockTx); err != nil {
+		return err
+	}
+
+	old, replacing := s.memPool.Find(blockTx.FromID, blockTx.Nonce)
+
+	if err := s.memPool.Upsert(blockTx); err != nil {
+		return err
+	}
+
+	if replacing {
+		action := "SPEEDUP"
+		if blockTx.IsCancel() {
+			action = "CANCEL"
+		}
+		s.EvHandler("viewer: state: SubmitTx: REPLACED: %s: tx[%s]: old[%s]: new[%s]: tip[%d -> %d]", action, blockTx, old.TxHash(), blockTx.TxHash(), old.Tip, blockTx.Tip)
+	}
+
+	s.seen.add(blockTx.TxHash())
+	s.Worker.SignalShareTx(blockTx)
+
+	// Development mode seals a block for every transaction so clients don't
+	// have to wait for the mempool to fill up.
+	if s.Consensus.Name() == consensus.DEV || s.MempoolLength() >= int64(s.Genesis.TransPerBlock) {
 		s.Worker.SignalStartMining()
 	}
 
 	return nil
 }
 
-func (s *State) Cancel() {
+// validateTx checks the transaction can be paid for and carries a nonce the
+// account hasn't used yet. Funds already committed to the account's other
+// pending transactions are taken into account. A pending transaction with
+// the same nonce is a replacement, so its cost is not counted.
+func (s *State) validateTx(tx database.BlockTx) error {
+	if tx.Tip < s.MinTip {
+		return fmt.Errorf("%w: got %d, minimum %d", ErrTipTooLow, tx.Tip, s.MinTip)
+	}
+
+	if tx.IsStake() && s.Consensus.Name() != consensus.POS {
+		return fmt.Errorf("%w: staking requires POS", ErrInvalidTx)
+	}
+
+	account, err := s.Db.Query(tx.FromID)
+	if err != nil && !errors.Is(err, database.NotFound) {
+		return errors.Wrap(err, "Error while querying account")
+	}
+
+	if tx.Nonce <= account.Nonce {
+		return fmt.Errorf("%w: got %d, account nonce is %d", ErrNonceUsed, tx.Nonce, account.Nonce)
+	}
+
+	var pending uint64
+	for _, ptx := range s.memPool.ForAccount(tx.FromID) {
+		if ptx.Nonce != tx.Nonce {
+			pending += txCost(ptx)
+		}
+	}
+
+	if cost := txCost(tx); uint64(account.Balance) < pending+cost {
+		return fmt.Errorf("%w: balance %d, pending %d, required %d", ErrInsufficientFunds, account.Balance, pending, cost)
+	}
+
+	return nil
+}
+
+// txCost returns the most a transaction can take from the sender's balance.
+func txCost(tx database.BlockTx) uint64 {
+	return tx.Value + tx.Tip + tx.GasUnits*tx.GasPrice
+}
+
+// CancelMining stops the mining operation in progress, if any.
+func (s *State) CancelMining() {
 	s.Worker.SignalCancelMining()
 }
 
+// ForceMining starts mining a block with whatever is in the mempool without
+// waiting for it to fill up or for the block interval. With POA and POS the
+// block is still only produced when it's this node's turn.
+func (s *State) ForceMining() error {
+	if s.Worker.IsPaused() {
+		return ErrMiningPaused
+	}
+
+	if s.MempoolLength() == 0 {
+		return ErrNothingToMine
+	}
+
+	s.Worker.SignalStartMining()
+
+	return nil
+}
+
+// PauseMining stops the node from mining blocks until ResumeMining is
+// called. The mining operation in progress, if any, is cancelled.
+func (s *State) PauseMining() {
+	s.Worker.PauseMining()
+}
+
+// ResumeMining lets the node mine blocks again after PauseMining.
+func (s *State) ResumeMining() {
+	s.Worker.ResumeMining()
+}
+
 func (s *State) GetStateRoot() string {
 	return s.Db.GetStateRoot()
 }
 
+// GetLastBlock returns the latest block in the chain.
 func (s *State) GetLastBlock() database.Block {
-	return database.Block{}
+	return s.Db.LatestBlock()
+}
+
+// MarkSynced records that the node has caught up with its peers.
+func (s *State) MarkSynced() {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	s.synced = true
+}
+
+// IsSynced reports whether the node has caught up with its peers.
+func (s *State) IsSynced() bool {
+	s.Mu.RLock()
+	defer s.Mu.RUnlock()
+
+	return s.synced
+}
+
+// KnownExternalPeers returns the known peers, excluding this node.
+func (s *State) KnownExternalPeers() []peer.Peer {
+	return s.KnownPeers.Copy(s.Host)
 }
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+)
+
+// iterator implements database.Iterator for every storage by finding the
+// blocks one at a time.
+type iterator struct {
+	find     func(blockNumber uint64) (database.Block, error)
+	next     uint64
+	last     uint64
+	backward bool
+	done     bool
+	block    database.Block
+	err      error
+}
+
+// newIterator resolves the range of an iteration against the number of the
+// latest stored block, as documented by database.Iterator.
+func newIterator(from, to, latest uint64, find func(uint64) (database.Block, error)) *iterator {
+	it := iterator{
+		find:     find,
+		backward: from == database.Latest || (to != database.Latest && from > to),
+	}
+
+	switch {
+	case it.backward:
+		if from > latest {
+			from = latest
+		}
+		if to == 0 {
+			to = 1
+		}
+		it.done = from < to
+
+	default:
+		if to > latest {
+			to = latest
+		}
+		if from == 0 {
+			from = 1
+		}
+		it.done = from > to
+	}
+
+	it.next = from
+	it.last = to
+
+	return &it
+}
+
+// Next finds the next block in the range.
+func (it *iterator) Next() bool {
+	if it.done {
+		return false
+	}
+
+	block, err := it.find(it.next)
+	if err != nil {
+		it.err = err
+		it.done = true
+		return false
+	}
+	it.block = block
+
+	switch {
+	case it.next == it.last:
+		it.done = true
+	case it.backward:
+		it.next--
+	default:
+		it.next++
+	}
+
+	return true
+}
+
+// Block returns the block Next moved to.
+func (it *iterator) Block() database.Block {
+	return it.block
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *iterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,129 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+)
+
+func TestIterate(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     uint64
+		to       uint64
+		expected []uint64
+	}{
+		{name: "forward", from: 1, to: 5, expected: []uint64{1, 2, 3, 4, 5}},
+		{name: "forward in the middle", from: 2, to: 4, expected: []uint64{2, 3, 4}},
+		{name: "single block", from: 3, to: 3, expected: []uint64{3}},
+		{name: "forward from zero", from: 0, to: 2, expected: []uint64{1, 2}},
+		{name: "forward to latest", from: 3, to: database.Latest, expected: []uint64{3, 4, 5}},
+		{name: "backward", from: 4, to: 2, expected: []uint64{4, 3, 2}},
+		{name: "backward to zero", from: 2, to: 0, expected: []uint64{2, 1}},
+		{name: "backward from latest", from: database.Latest, to: 3, expected: []uint64{5, 4, 3}},
+		{name: "whole chain backward", from: database.Latest, to: 0, expected: []uint64{5, 4, 3, 2, 1}},
+		{name: "forward past latest", from: 4, to: 100, expected: []uint64{4, 5}},
+		{name: "backward from past latest", from: 100, to: 4, expected: []uint64{5, 4}},
+		{name: "forward out of range", from: 6, to: 9},
+		{name: "forward from past latest to latest", from: 6, to: database.Latest},
+	}
+
+	storages := []struct {
+		name string
+		open func(t *testing.T, blocks []database.Block) database.Storage
+	}{
+		{
+			name: "memory",
+			open: func(t *testing.T, blocks []database.Block) database.Storage {
+				st := storage.NewMemoryStorage()
+				saveBlocks(t, st, blocks)
+				return st
+			},
+		},
+		{
+			name: "disk",
+			open: func(t *testing.T, blocks []database.Block) database.Storage {
+				dir := t.TempDir()
+				saveBlocks(t, openDisk(t, dir), blocks)
+
+				// The latest block must be found when the storage is opened
+				// on blocks that are already stored.
+				return openDisk(t, dir)
+			},
+		},
+		{
+			name: "log",
+			open: func(t *testing.T, blocks []database.Block) database.Storage {
+				log := openLog(t, t.TempDir(), 0)
+				t.Cleanup(func() { log.Close() })
+				saveBlocks(t, log, blocks)
+				return log
+			},
+		},
+	}
+
+	blocks := newBlocks(t, 5)
+
+	for _, s := range storages {
+		t.Run(s.name, func(t *testing.T) {
+			st := s.open(t, blocks)
+
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					checkNumbers(t, st.Iterate(tt.from, tt.to), tt.expected)
+				})
+			}
+
+			t.Run("empty", func(t *testing.T) {
+				checkNumbers(t, s.open(t, nil).Iterate(1, database.Latest), nil)
+			})
+		})
+	}
+}
+
+func TestDiskStorageIterateLatest(t *testing.T) {
+	blocks := newBlocks(t, 5)
+
+	disk := openDisk(t, t.TempDir())
+
+	saveBlocks(t, disk, blocks[:3])
+	checkNumbers(t, disk.Iterate(1, database.Latest), []uint64{1, 2, 3})
+
+	saveBlocks(t, disk, blocks[3:])
+	checkNumbers(t, disk.Iterate(database.Latest, 4), []uint64{5, 4})
+
+	if err := disk.Delete(5); err != nil {
+		t.Fatalf("deleting block: %s", err)
+	}
+	checkNumbers(t, disk.Iterate(database.Latest, 0), []uint64{4, 3, 2, 1})
+
+	// Saving over a block in the middle doesn't move the latest block back.
+	saveBlocks(t, disk, blocks[1:2])
+	checkNumbers(t, disk.Iterate(3, database.Latest), []uint64{3, 4})
+}
+
+// =============================================================================
+
+// checkNumbers checks the iterator returns the blocks with the numbers in
+// order.
+func checkNumbers(t *testing.T, it database.Iterator, expected []uint64) {
+	t.Helper()
+
+	var got []uint64
+	for it.Next() {
+		got = append(got, it.Block().Header.Number)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("iterating: %s", err)
+	}
+
+	if len(got) != len(expected) {
+		t.Fatalf("got blocks %v, expected %v", got, expected)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("got blocks %v, expected %v", got, expected)
+		}
+	}
+}
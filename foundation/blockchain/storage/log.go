@@ -0,0 +1,806 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"emperror.dev/errors"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+)
+
+// DefaultSegmentSize is the size a segment grows to before the next block is
+// written to a new one.
+const DefaultSegmentSize = 64 << 20
+
+const (
+	segmentSuffix = ".seg"
+	indexName     = "index"
+
+	recordHeaderSize = 8  // Length and CRC32 of the block.
+	indexEntrySize   = 16 // Segment, offset and length of a record.
+)
+
+// ErrReadOnly is returned when a log opened with OpenLogStorageReadOnly is
+// asked to change.
+var ErrReadOnly = errors.New("storage is read only")
+
+// indexEntry locates the record of a block. Block N is entry N-1.
+type indexEntry struct {
+	segment uint32
+	offset  uint64
+	length  uint32
+}
+
+// encode returns the entry as it's stored in the index.
+func (e indexEntry) encode() []byte {
+	b := make([]byte, indexEntrySize)
+	binary.BigEndian.PutUint32(b[0:4], e.segment)
+	binary.BigEndian.PutUint64(b[4:12], e.offset)
+	binary.BigEndian.PutUint32(b[12:16], e.length)
+
+	return b
+}
+
+// LogStorage appends the blocks to segment files in order, in the binary
+// block encoding. The index holds where every block starts, so finding a
+// block is a single read. The index is rebuilt from the segments when it's
+// behind or damaged after a crash.
+//
+// Every record is the length and CRC32 of the block followed by the block:
+//
+//	| length uint32 | crc32 uint32 | block ... |
+type LogStorage struct {
+	mu          sync.RWMutex
+	folderName  string
+	segmentSize int64
+	segments    []*os.File
+	index       *os.File
+	entries     []indexEntry
+	ev          func(v string, args ...interface{})
+	readOnly    bool
+
+	prunedBefore uint64
+	sealed       bool // The next block starts a new segment.
+}
+
+// NewLogStorage opens the log in the folder, creating it when needed, and
+// recovers the index. A zero segment size uses DefaultSegmentSize and the
+// event handler can be nil.
+func NewLogStorage(folderName string, segmentSize int64, ev func(v string, args ...interface{})) (*LogStorage, error) {
+	if err := os.MkdirAll(folderName, 0755); err != nil {
+		return nil, errors.Wrap(err, "Error while creating directory")
+	}
+
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+
+	if ev == nil {
+		ev = func(v string, args ...interface{}) {}
+	}
+
+	l := LogStorage{
+		folderName:  folderName,
+		segmentSize: segmentSize,
+		ev:          ev,
+	}
+
+	if err := l.open(); err != nil {
+		l.closeFiles()
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+// OpenLogStorageReadOnly opens an existing log without changing anything in
+// the folder, so it's safe to read a log a running node is writing to. The
+// index is recovered in memory only and the blocks saved after the log was
+// opened aren't seen.
+func OpenLogStorageReadOnly(folderName string) (*LogStorage, error) {
+	if _, err := os.Stat(folderName); err != nil {
+		return nil, errors.Wrap(err, "Error while opening directory")
+	}
+
+	l := LogStorage{
+		folderName:  folderName,
+		segmentSize: DefaultSegmentSize,
+		ev:          func(v string, args ...interface{}) {},
+		readOnly:    true,
+	}
+
+	if err := l.open(); err != nil {
+		l.closeFiles()
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+// Save appends the block to the log. Saving a block number that is already
+// in the log replaces it and drops every block after it, unless that drops
+// the snapshot block of a pruned node.
+func (l *LogStorage) Save(block database.Block) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.readOnly {
+		return ErrReadOnly
+	}
+
+	number := block.Header.Number
+	switch count := uint64(len(l.entries)); {
+	case number == 0:
+		return errors.New("Block number must be greater than 0")
+	case number > count+1:
+		return fmt.Errorf("block %d can't be saved after block %d", number, count)
+	case number <= count:
+		if err := checkSnapshot(l.folderName, number); err != nil {
+			return err
+		}
+		if err := l.truncate(number - 1); err != nil {
+			return err
+		}
+	}
+
+	record, err := encodeRecord(database.NewBlockData(block))
+	if err != nil {
+		return err
+	}
+
+	segment, offset, err := l.activeSegment(int64(len(record)))
+	if err != nil {
+		return err
+	}
+
+	file := l.segments[segment]
+	if _, err := file.WriteAt(record, offset); err != nil {
+		return errors.Wrap(err, "Error while writing block")
+	}
+	if err := file.Sync(); err != nil {
+		return errors.Wrap(err, "Error while syncing segment")
+	}
+
+	// The index isn't synced. A crash can lose the last entries, which are
+	// recovered from the segment the next time the log is opened.
+	entry := indexEntry{
+		segment: uint32(segment),
+		offset:  uint64(offset),
+		length:  uint32(len(record) - recordHeaderSize),
+	}
+	if err := l.writeEntry(len(l.entries), entry); err != nil {
+		return err
+	}
+	l.entries = append(l.entries, entry)
+
+	return nil
+}
+
+// Delete removes the last block. The log is append only, so other blocks
+// can only be removed by saving over them.
+func (l *LogStorage) Delete(blockNumber uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.readOnly {
+		return ErrReadOnly
+	}
+
+	if blockNumber == 0 || blockNumber != uint64(len(l.entries)) {
+		return fmt.Errorf("only the last block %d can be deleted", len(l.entries))
+	}
+
+	if err := checkSnapshot(l.folderName, blockNumber); err != nil {
+		return err
+	}
+
+	return l.truncate(blockNumber - 1)
+}
+
+// Find reads the block with the specified number. A record that doesn't
+// match its checksum returns a CorruptedError. A pruned block returns
+// database.ErrPruned.
+func (l *LogStorage) Find(blockNumber uint64) (database.Block, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	blockData, err := l.readBlockData(blockNumber)
+	if err != nil {
+		return database.Block{}, err
+	}
+
+	if blockData.Pruned {
+		return database.Block{}, fmt.Errorf("block %d: %w", blockNumber, database.ErrPruned)
+	}
+
+	block, err := database.ToBlock(blockData)
+	if err != nil {
+		return database.Block{}, &CorruptedError{Number: blockNumber, Reason: err.Error()}
+	}
+
+	return block, nil
+}
+
+// FindHeader reads the header of the block with the specified number, which
+// is kept when the block is pruned.
+func (l *LogStorage) FindHeader(blockNumber uint64) (database.BlockHeader, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	blockData, err := l.readBlockData(blockNumber)
+	if err != nil {
+		return database.BlockHeader{}, err
+	}
+
+	return blockData.Header, nil
+}
+
+// Prune writes the snapshot and then compacts every segment that only holds
+// blocks before the specified number, rewriting them without their
+// transactions. A segment is compacted as a whole, so when the segment being
+// written to also holds later blocks it's sealed instead, and the next block
+// starts a new segment. The sealed segment is compacted by a later prune,
+// once the number passes its last block.
+func (l *LogStorage) Prune(before uint64, snapshot database.Snapshot) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.readOnly {
+		return ErrReadOnly
+	}
+
+	if err := writeSnapshot(l.folderName, snapshot, l.prunedBefore); err != nil {
+		return err
+	}
+
+	first := 0
+	for first < len(l.entries) {
+		segment := l.entries[first].segment
+
+		last := first
+		for last+1 < len(l.entries) && l.entries[last+1].segment == segment {
+			last++
+		}
+
+		lastNumber := uint64(last) + 1
+		if lastNumber >= before {
+			if int(segment) == len(l.segments)-1 && uint64(first)+1 < before {
+				l.sealed = true
+				l.ev("storage: log: prune: segment[%d]: sealed", segment)
+			}
+			break
+		}
+
+		if lastNumber >= l.prunedBefore {
+			if err := l.compact(first, last); err != nil {
+				return err
+			}
+			l.ev("storage: log: prune: segment[%d]: blocks %d to %d pruned", segment, first+1, lastNumber)
+		}
+
+		first = last + 1
+	}
+
+	if err := writeSnapshot(l.folderName, snapshot, before); err != nil {
+		return err
+	}
+	l.prunedBefore = before
+
+	return nil
+}
+
+// Snapshot returns the snapshot written by the last prune, if any.
+func (l *LogStorage) Snapshot() (database.Snapshot, bool, error) {
+	snapshot, _, exists, err := readSnapshot(l.folderName)
+	return snapshot, exists, err
+}
+
+// Iterate returns an iterator over the blocks between the specified numbers.
+func (l *LogStorage) Iterate(from, to uint64) database.Iterator {
+	l.mu.RLock()
+	latest := uint64(len(l.entries))
+	l.mu.RUnlock()
+
+	return newIterator(from, to, latest, l.Find)
+}
+
+// Truncate drops the first corrupted block and every block after it. It
+// returns the number of the last good block, zero when there is none. Nothing
+// is dropped when the bad block is at or below the snapshot of a pruned node,
+// which returns ErrBelowSnapshot.
+func (l *LogStorage) Truncate() (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	var lastGood uint64
+	for number := uint64(1); number <= uint64(len(l.entries)); number++ {
+		if _, err := l.readBlockData(number); err != nil {
+			break
+		}
+		lastGood = number
+	}
+
+	if lastGood < uint64(len(l.entries)) {
+		if err := checkSnapshot(l.folderName, lastGood+1); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := l.truncate(lastGood); err != nil {
+		return 0, err
+	}
+
+	return lastGood, nil
+}
+
+// Close flushes the index and closes the files.
+func (l *LogStorage) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var err error
+	if l.index != nil && !l.readOnly {
+		err = l.index.Sync()
+	}
+
+	if cerr := l.closeFiles(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// =============================================================================
+
+// open opens the segments and the index and brings the index up to date with
+// the records in the segments.
+func (l *LogStorage) open() error {
+	_, prunedBefore, _, err := readSnapshot(l.folderName)
+	if err != nil {
+		return err
+	}
+	l.prunedBefore = prunedBefore
+
+	// A compaction that didn't finish leaves its temporary segment behind.
+	// In read only mode it may be a compaction that is still running.
+	if !l.readOnly {
+		leftovers, err := filepath.Glob(filepath.Join(l.folderName, "*"+segmentSuffix+tmpSuffix))
+		if err != nil {
+			return errors.Wrap(err, "Error while listing segments")
+		}
+		for _, name := range leftovers {
+			if err := os.Remove(name); err != nil {
+				return errors.Wrap(err, "Error while deleting temporary segment")
+			}
+		}
+	}
+
+	flag := os.O_RDWR
+	if l.readOnly {
+		flag = os.O_RDONLY
+	}
+
+	names, err := filepath.Glob(filepath.Join(l.folderName, "*"+segmentSuffix))
+	if err != nil {
+		return errors.Wrap(err, "Error while listing segments")
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		if name != l.segmentName(i) {
+			return fmt.Errorf("segment %s is missing", l.segmentName(i))
+		}
+
+		file, err := os.OpenFile(name, flag, 0644)
+		if err != nil {
+			return errors.Wrap(err, "Error while opening segment")
+		}
+		l.segments = append(l.segments, file)
+	}
+
+	if !l.readOnly {
+		flag |= os.O_CREATE
+	}
+
+	l.index, err = os.OpenFile(filepath.Join(l.folderName, indexName), flag, 0644)
+	if err != nil {
+		return errors.Wrap(err, "Error while opening index")
+	}
+
+	return l.recover()
+}
+
+// recover loads the index entries up to the last one pointing at a valid
+// record and scans the segments after it for records the index is missing.
+// A torn record at the end of the log is cut off, unless that drops the
+// snapshot block of a pruned node, which returns ErrBelowSnapshot.
+func (l *LogStorage) recover() error {
+	content, err := io.ReadAll(l.index)
+	if err != nil {
+		return errors.Wrap(err, "Error while reading index")
+	}
+
+	var next indexEntry
+	for i := 0; i+indexEntrySize <= len(content); i += indexEntrySize {
+		entry := indexEntry{
+			segment: binary.BigEndian.Uint32(content[i : i+4]),
+			offset:  binary.BigEndian.Uint64(content[i+4 : i+12]),
+			length:  binary.BigEndian.Uint32(content[i+12 : i+16]),
+		}
+
+		// Records are contiguous, so every entry must start where the
+		// previous record ended or at the start of the next segment.
+		if entry != (indexEntry{next.segment, next.offset, entry.length}) && entry != (indexEntry{next.segment + 1, 0, entry.length}) {
+			break
+		}
+
+		l.entries = append(l.entries, entry)
+		next = indexEntry{segment: entry.segment, offset: entry.offset + recordHeaderSize + uint64(entry.length)}
+	}
+
+	// A compaction rewrites a whole segment before the index. If it didn't
+	// get to the index, the entries of that segment point at the old
+	// records and the index is rebuilt from the segments.
+	for i, entry := range l.entries {
+		if i+1 == len(l.entries) || l.entries[i+1].segment == entry.segment {
+			continue
+		}
+		if _, err := l.readRecord(entry); err != nil {
+			l.ev("storage: log: recover: segment[%d]: index is stale", entry.segment)
+			l.entries = nil
+			next = indexEntry{}
+			break
+		}
+	}
+
+	// Only the records written last can be incomplete, so the entries are
+	// checked from the end until one points at a good record.
+	for n := len(l.entries); n > 0; n-- {
+		last := l.entries[n-1]
+		if _, err := l.readRecord(last); err == nil {
+			break
+		}
+		l.entries = l.entries[:n-1]
+		next = indexEntry{segment: last.segment, offset: last.offset}
+	}
+	indexed := len(l.entries)
+
+	for segment := int(next.segment); segment < len(l.segments); segment++ {
+		offset := uint64(0)
+		if segment == int(next.segment) {
+			offset = next.offset
+		}
+
+		for {
+			entry, err := l.scanRecord(segment, offset)
+			if err != nil {
+				break
+			}
+			l.entries = append(l.entries, entry)
+			offset += recordHeaderSize + uint64(entry.length)
+		}
+
+		// Anything after the last good record is a write that didn't finish.
+		// Later segments can't have valid records once one is cut short.
+		info, err := l.segments[segment].Stat()
+		if err != nil {
+			return errors.Wrap(err, "Error while reading segment")
+		}
+		if uint64(info.Size()) > offset {
+			if l.readOnly {
+				break
+			}
+			if err := checkSnapshot(l.folderName, uint64(len(l.entries))+1); err != nil {
+				return err
+			}
+			l.ev("storage: log: recover: segment[%d]: truncating torn record at offset %d", segment, offset)
+			if err := l.truncateSegments(segment, offset); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	if !l.readOnly && (len(l.entries) != indexed || len(content) != indexed*indexEntrySize) {
+		l.ev("storage: log: recover: index rebuilt: indexed[%d]: recovered[%d]", indexed, len(l.entries))
+		if err := l.rewriteIndex(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scanRecord reads the record at the offset of the segment and returns its
+// entry when it's complete and matches its checksum.
+func (l *LogStorage) scanRecord(segment int, offset uint64) (indexEntry, error) {
+	var header [recordHeaderSize]byte
+	if _, err := l.segments[segment].ReadAt(header[:], int64(offset)); err != nil {
+		return indexEntry{}, err
+	}
+
+	entry := indexEntry{
+		segment: uint32(segment),
+		offset:  offset,
+		length:  binary.BigEndian.Uint32(header[0:4]),
+	}
+
+	if _, err := l.readRecord(entry); err != nil {
+		return indexEntry{}, err
+	}
+
+	return entry, nil
+}
+
+// readRecord reads the block bytes of the record and checks them against the
+// checksum in the record header.
+func (l *LogStorage) readRecord(entry indexEntry) ([]byte, error) {
+	if int(entry.segment) >= len(l.segments) {
+		return nil, fmt.Errorf("segment %d is missing", entry.segment)
+	}
+
+	file := l.segments[entry.segment]
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if entry.offset+recordHeaderSize+uint64(entry.length) > uint64(info.Size()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	record := make([]byte, recordHeaderSize+int(entry.length))
+	if _, err := file.ReadAt(record, int64(entry.offset)); err != nil {
+		return nil, err
+	}
+
+	if binary.BigEndian.Uint32(record[0:4]) != entry.length {
+		return nil, errors.New("record length doesn't match the index")
+	}
+
+	payload := record[recordHeaderSize:]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(record[4:8]) {
+		return nil, errors.New("checksum mismatch")
+	}
+
+	return payload, nil
+}
+
+// readBlockData decodes the block with the specified number. The caller
+// must hold the lock.
+func (l *LogStorage) readBlockData(blockNumber uint64) (database.BlockData, error) {
+	if blockNumber == 0 || blockNumber > uint64(len(l.entries)) {
+		return database.BlockData{}, errors.Errorf("Block %d not found", blockNumber)
+	}
+
+	payload, err := l.readRecord(l.entries[blockNumber-1])
+	if err != nil {
+		return database.BlockData{}, &CorruptedError{Number: blockNumber, Reason: err.Error()}
+	}
+
+	var blockData database.BlockData
+	if err := blockData.UnmarshalBinary(payload); err != nil {
+		return database.BlockData{}, &CorruptedError{Number: blockNumber, Reason: err.Error()}
+	}
+
+	if blockData.Header.Number != blockNumber {
+		return database.BlockData{}, &CorruptedError{Number: blockNumber, Reason: fmt.Sprintf("record holds block %d", blockData.Header.Number)}
+	}
+
+	return blockData, nil
+}
+
+// compact rewrites the segment holding the entries from first to last, both
+// included, without the transactions of the blocks in it. The new segment is
+// written to a temporary file and renamed over the old one.
+func (l *LogStorage) compact(first, last int) error {
+	segment := int(l.entries[first].segment)
+	name := l.segmentName(segment)
+
+	file, err := os.OpenFile(name+tmpSuffix, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrap(err, "Error while creating segment")
+	}
+
+	entries := make([]indexEntry, 0, last-first+1)
+	var offset uint64
+	for i := first; i <= last; i++ {
+		blockData, err := l.readBlockData(uint64(i) + 1)
+		if err != nil {
+			file.Close()
+			return err
+		}
+
+		record, err := encodeRecord(database.NewPrunedBlockData(blockData.Header))
+		if err != nil {
+			file.Close()
+			return err
+		}
+
+		if _, err := file.WriteAt(record, int64(offset)); err != nil {
+			file.Close()
+			return errors.Wrap(err, "Error while writing segment")
+		}
+
+		entries = append(entries, indexEntry{
+			segment: uint32(segment),
+			offset:  offset,
+			length:  uint32(len(record) - recordHeaderSize),
+		})
+		offset += uint64(len(record))
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return errors.Wrap(err, "Error while syncing segment")
+	}
+
+	if err := os.Rename(name+tmpSuffix, name); err != nil {
+		file.Close()
+		return errors.Wrap(err, "Error while renaming segment")
+	}
+
+	if err := syncDir(l.folderName); err != nil {
+		file.Close()
+		return errors.Wrap(err, "Error while syncing directory")
+	}
+
+	l.segments[segment].Close()
+	l.segments[segment] = file
+	copy(l.entries[first:], entries)
+
+	return l.rewriteIndex()
+}
+
+// activeSegment returns the segment and offset the next record is written
+// at, starting a new segment when the current one is full.
+func (l *LogStorage) activeSegment(size int64) (int, int64, error) {
+	if len(l.segments) > 0 {
+		segment := len(l.segments) - 1
+
+		var offset int64
+		if n := len(l.entries); n > 0 && int(l.entries[n-1].segment) == segment {
+			last := l.entries[n-1]
+			offset = int64(last.offset) + recordHeaderSize + int64(last.length)
+		}
+
+		if offset == 0 || (!l.sealed && offset+size <= l.segmentSize) {
+			return segment, offset, nil
+		}
+	}
+
+	file, err := os.OpenFile(l.segmentName(len(l.segments)), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "Error while creating segment")
+	}
+	l.segments = append(l.segments, file)
+	l.sealed = false
+
+	if err := syncDir(l.folderName); err != nil {
+		return 0, 0, errors.Wrap(err, "Error while syncing directory")
+	}
+
+	return len(l.segments) - 1, 0, nil
+}
+
+// truncate drops every block after the specified number from the segments
+// and the index.
+func (l *LogStorage) truncate(blockNumber uint64) error {
+	if blockNumber >= uint64(len(l.entries)) {
+		return nil
+	}
+
+	first := l.entries[blockNumber]
+	if err := l.truncateSegments(int(first.segment), first.offset); err != nil {
+		return err
+	}
+
+	l.entries = l.entries[:blockNumber]
+
+	if err := l.index.Truncate(int64(blockNumber) * indexEntrySize); err != nil {
+		return errors.Wrap(err, "Error while truncating index")
+	}
+
+	return nil
+}
+
+// truncateSegments cuts the segment at the offset and removes the segments
+// after it.
+func (l *LogStorage) truncateSegments(segment int, offset uint64) error {
+	if err := l.segments[segment].Truncate(int64(offset)); err != nil {
+		return errors.Wrap(err, "Error while truncating segment")
+	}
+	if err := l.segments[segment].Sync(); err != nil {
+		return errors.Wrap(err, "Error while syncing segment")
+	}
+
+	for i := len(l.segments) - 1; i > segment; i-- {
+		l.segments[i].Close()
+		if err := os.Remove(l.segmentName(i)); err != nil {
+			return errors.Wrap(err, "Error while deleting segment")
+		}
+	}
+	l.segments = l.segments[:segment+1]
+
+	return syncDir(l.folderName)
+}
+
+// writeEntry writes the index entry at the specified position.
+func (l *LogStorage) writeEntry(position int, entry indexEntry) error {
+	if _, err := l.index.WriteAt(entry.encode(), int64(position)*indexEntrySize); err != nil {
+		return errors.Wrap(err, "Error while writing index")
+	}
+
+	return nil
+}
+
+// rewriteIndex replaces the content of the index with the entries in memory.
+func (l *LogStorage) rewriteIndex() error {
+	content := make([]byte, 0, len(l.entries)*indexEntrySize)
+	for _, entry := range l.entries {
+		content = append(content, entry.encode()...)
+	}
+
+	if err := l.index.Truncate(0); err != nil {
+		return errors.Wrap(err, "Error while truncating index")
+	}
+	if _, err := l.index.WriteAt(content, 0); err != nil {
+		return errors.Wrap(err, "Error while writing index")
+	}
+	if err := l.index.Sync(); err != nil {
+		return errors.Wrap(err, "Error while syncing index")
+	}
+
+	return nil
+}
+
+// encodeRecord returns the record for the block: the length and CRC32 of its
+// binary encoding followed by it.
+func encodeRecord(blockData database.BlockData) ([]byte, error) {
+	payload, err := blockData.MarshalBinary()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while encoding block")
+	}
+
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderSize:], payload)
+
+	return record, nil
+}
+
+// closeFiles closes the segments and the index.
+func (l *LogStorage) closeFiles() error {
+	var err error
+	for _, file := range l.segments {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	l.segments = nil
+
+	if l.index != nil {
+		if cerr := l.index.Close(); err == nil {
+			err = cerr
+		}
+		l.index = nil
+	}
+
+	return err
+}
+
+// segmentName returns the file name of the specified segment.
+func (l *LogStorage) segmentName(segment int) string {
+	return filepath.Join(l.folderName, fmt.Sprintf("%010d%s", segment, segmentSuffix))
+}
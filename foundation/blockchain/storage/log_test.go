@@ -0,0 +1,618 @@
+package storage_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+)
+
+func TestLogRecover(t *testing.T) {
+	tests := []struct {
+		name     string
+		damage   func(t *testing.T, dir string)
+		expected uint64 // Number of the last block after reopening.
+	}{
+		{
+			name:     "clean close",
+			damage:   func(t *testing.T, dir string) {},
+			expected: 5,
+		},
+		{
+			name: "torn last record",
+			damage: func(t *testing.T, dir string) {
+				name := segmentName(dir, 0)
+				offsets := recordOffsets(t, name)
+				cut(t, name, offsets[4]+10)
+			},
+			expected: 4,
+		},
+		{
+			name: "torn record header",
+			damage: func(t *testing.T, dir string) {
+				name := segmentName(dir, 0)
+				offsets := recordOffsets(t, name)
+				cut(t, name, offsets[4]+3)
+			},
+			expected: 4,
+		},
+		{
+			name: "corrupted last record",
+			damage: func(t *testing.T, dir string) {
+				offsets := recordOffsets(t, segmentName(dir, 0))
+				flipByte(t, segmentName(dir, 0), offsets[4]+20)
+			},
+			expected: 4,
+		},
+		{
+			name: "index behind",
+			damage: func(t *testing.T, dir string) {
+				cut(t, filepath.Join(dir, "index"), 2*16)
+			},
+			expected: 5,
+		},
+		{
+			name: "index with a torn entry",
+			damage: func(t *testing.T, dir string) {
+				cut(t, filepath.Join(dir, "index"), 3*16+7)
+			},
+			expected: 5,
+		},
+		{
+			name: "index missing",
+			damage: func(t *testing.T, dir string) {
+				if err := os.Remove(filepath.Join(dir, "index")); err != nil {
+					t.Fatalf("removing index: %s", err)
+				}
+			},
+			expected: 5,
+		},
+		{
+			name: "index pointing at the wrong records",
+			damage: func(t *testing.T, dir string) {
+				flipByte(t, filepath.Join(dir, "index"), 2*16+11)
+			},
+			expected: 5,
+		},
+	}
+
+	blocks := newBlocks(t, 5)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			log := openLog(t, dir, 0)
+			saveBlocks(t, log, blocks)
+			closeLog(t, log)
+
+			tt.damage(t, dir)
+
+			log = openLog(t, dir, 0)
+			defer closeLog(t, log)
+
+			checkBlocks(t, log, blocks[:tt.expected])
+
+			// The log must accept the blocks it lost where the recovered
+			// ones end.
+			saveBlocks(t, log, blocks[tt.expected:])
+			checkBlocks(t, log, blocks)
+		})
+	}
+}
+
+func TestLogRecoverSegments(t *testing.T) {
+	blocks := newBlocks(t, 5)
+
+	dir := t.TempDir()
+
+	// Every block is written to a segment of its own.
+	log := openLog(t, dir, 1)
+	saveBlocks(t, log, blocks)
+	closeLog(t, log)
+
+	// A torn record in the middle segment drops every block after it.
+	name := segmentName(dir, 2)
+	cut(t, name, recordOffsets(t, name)[0]+10)
+
+	log = openLog(t, dir, 1)
+	checkBlocks(t, log, blocks[:2])
+
+	if _, err := os.Stat(segmentName(dir, 3)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("segment after the torn record wasn't removed: %v", err)
+	}
+
+	saveBlocks(t, log, blocks[2:])
+	closeLog(t, log)
+
+	log = openLog(t, dir, 1)
+	defer closeLog(t, log)
+
+	checkBlocks(t, log, blocks)
+}
+
+func TestLogRecoverStaleIndexAfterCompact(t *testing.T) {
+	blocks := newBlocks(t, 5)
+
+	dir := t.TempDir()
+
+	log := openLog(t, dir, 1)
+	saveBlocks(t, log, blocks)
+
+	// A crash after the segments were compacted but before the index was
+	// written leaves the index pointing at the records before the prune.
+	index, err := os.ReadFile(filepath.Join(dir, "index"))
+	if err != nil {
+		t.Fatalf("reading index: %s", err)
+	}
+
+	if err := log.Prune(4, database.Snapshot{Header: blocks[2].Header}); err != nil {
+		t.Fatalf("pruning: %s", err)
+	}
+	closeLog(t, log)
+
+	if err := os.WriteFile(filepath.Join(dir, "index"), index, 0644); err != nil {
+		t.Fatalf("writing index: %s", err)
+	}
+
+	log = openLog(t, dir, 1)
+	defer closeLog(t, log)
+
+	for _, block := range blocks[:3] {
+		number := block.Header.Number
+
+		if _, err := log.Find(number); !errors.Is(err, database.ErrPruned) {
+			t.Errorf("block %d: expected a pruned block, got %v", number, err)
+		}
+
+		header, err := log.FindHeader(number)
+		if err != nil {
+			t.Fatalf("block %d: finding header: %s", number, err)
+		}
+		if hash := (database.Block{Header: header}).Hash(); hash != block.Hash() {
+			t.Errorf("block %d: got hash %s, expected %s", number, hash, block.Hash())
+		}
+	}
+
+	checkRange(t, log, 4, database.Latest, blocks[3:])
+}
+
+func TestLogTruncate(t *testing.T) {
+	tests := []struct {
+		name        string
+		segmentSize int64
+		damage      func(t *testing.T, dir string)
+		lastGood    uint64
+	}{
+		{
+			name:     "nothing corrupted",
+			damage:   func(t *testing.T, dir string) {},
+			lastGood: 5,
+		},
+		{
+			name: "corrupted block in the middle",
+			damage: func(t *testing.T, dir string) {
+				offsets := recordOffsets(t, segmentName(dir, 0))
+				flipByte(t, segmentName(dir, 0), offsets[2]+20)
+			},
+			lastGood: 2,
+		},
+		{
+			name: "corrupted first block",
+			damage: func(t *testing.T, dir string) {
+				flipByte(t, segmentName(dir, 0), 20)
+			},
+			lastGood: 0,
+		},
+		{
+			name:        "corrupted segment",
+			segmentSize: 1,
+			damage: func(t *testing.T, dir string) {
+				flipByte(t, segmentName(dir, 3), 20)
+			},
+			lastGood: 3,
+		},
+	}
+
+	blocks := newBlocks(t, 5)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			log := openLog(t, dir, tt.segmentSize)
+			saveBlocks(t, log, blocks)
+			closeLog(t, log)
+
+			// Only the end of the log is checked when it's opened, so the
+			// damage is left for Truncate to find.
+			tt.damage(t, dir)
+
+			log = openLog(t, dir, tt.segmentSize)
+
+			lastGood, err := log.Truncate()
+			if err != nil {
+				t.Fatalf("truncating: %s", err)
+			}
+			if lastGood != tt.lastGood {
+				t.Errorf("last good block: got %d, expected %d", lastGood, tt.lastGood)
+			}
+
+			checkBlocks(t, log, blocks[:tt.lastGood])
+
+			// The dropped blocks can be saved again and survive a reopen.
+			saveBlocks(t, log, blocks[tt.lastGood:])
+			closeLog(t, log)
+
+			log = openLog(t, dir, tt.segmentSize)
+			defer closeLog(t, log)
+
+			checkBlocks(t, log, blocks)
+		})
+	}
+}
+
+func TestLogPrune(t *testing.T) {
+	tests := []struct {
+		name        string
+		segmentSize int64
+		before      uint64
+		pruned      uint64 // Number of the last block left without transactions.
+	}{
+		{name: "segment per block", segmentSize: 1, before: 4, pruned: 3},
+		{name: "whole segment being written to", before: 6, pruned: 5},
+		{name: "segment being written to holding later blocks", before: 4, pruned: 0},
+	}
+
+	blocks := newBlocks(t, 5)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			log := openLog(t, dir, tt.segmentSize)
+			saveBlocks(t, log, blocks)
+
+			snapshot := database.Snapshot{Header: blocks[tt.before-2].Header}
+			if err := log.Prune(tt.before, snapshot); err != nil {
+				t.Fatalf("pruning: %s", err)
+			}
+
+			checkPruned(t, log, blocks, tt.pruned)
+			closeLog(t, log)
+
+			log = openLog(t, dir, tt.segmentSize)
+			defer closeLog(t, log)
+
+			checkPruned(t, log, blocks, tt.pruned)
+
+			got, exists, err := log.Snapshot()
+			if err != nil || !exists {
+				t.Fatalf("reading snapshot: %v, exists %t", err, exists)
+			}
+			if got.Header.Number != snapshot.Header.Number {
+				t.Errorf("snapshot at block %d, expected %d", got.Header.Number, snapshot.Header.Number)
+			}
+		})
+	}
+}
+
+func TestLogPruneSealsSegment(t *testing.T) {
+	blocks := newBlocks(t, 7)
+
+	dir := t.TempDir()
+
+	log := openLog(t, dir, 0)
+	defer closeLog(t, log)
+
+	saveBlocks(t, log, blocks[:5])
+
+	// The segment also holds blocks that keep their transactions, so nothing
+	// is pruned yet and the next block starts a new segment.
+	if err := log.Prune(4, database.Snapshot{Header: blocks[2].Header}); err != nil {
+		t.Fatalf("pruning: %s", err)
+	}
+	checkPruned(t, log, blocks[:5], 0)
+
+	saveBlocks(t, log, blocks[5:])
+	if _, err := os.Stat(segmentName(dir, 1)); err != nil {
+		t.Fatalf("block after the prune wasn't written to a new segment: %s", err)
+	}
+
+	// Once every block of the sealed segment is before the number, the
+	// segment is compacted and the new one is left alone.
+	if err := log.Prune(6, database.Snapshot{Header: blocks[4].Header}); err != nil {
+		t.Fatalf("pruning: %s", err)
+	}
+	checkPruned(t, log, blocks, 5)
+}
+
+func TestLogBelowSnapshot(t *testing.T) {
+	blocks := newBlocks(t, 5)
+
+	dir := t.TempDir()
+
+	log := openLog(t, dir, 0)
+	saveBlocks(t, log, blocks)
+
+	if err := log.Prune(3, database.Snapshot{Header: blocks[3].Header}); err != nil {
+		t.Fatalf("pruning: %s", err)
+	}
+
+	if err := log.Save(blocks[3]); !errors.Is(err, storage.ErrBelowSnapshot) {
+		t.Errorf("saving over the snapshot block: expected ErrBelowSnapshot, got %v", err)
+	}
+	if err := log.Save(blocks[4]); err != nil {
+		t.Errorf("saving over the block after the snapshot: %s", err)
+	}
+	if err := log.Delete(5); err != nil {
+		t.Errorf("deleting the block after the snapshot: %s", err)
+	}
+	if err := log.Delete(4); !errors.Is(err, storage.ErrBelowSnapshot) {
+		t.Errorf("deleting the snapshot block: expected ErrBelowSnapshot, got %v", err)
+	}
+	closeLog(t, log)
+
+	// A corrupted block below the snapshot can't be truncated away.
+	offsets := recordOffsets(t, segmentName(dir, 0))
+	flipByte(t, segmentName(dir, 0), offsets[1]+20)
+
+	log = openLog(t, dir, 0)
+	defer closeLog(t, log)
+
+	if _, err := log.Truncate(); !errors.Is(err, storage.ErrBelowSnapshot) {
+		t.Errorf("truncating: expected ErrBelowSnapshot, got %v", err)
+	}
+	checkRange(t, log, 4, 4, blocks[3:4])
+}
+
+func TestLogRecoverBelowSnapshot(t *testing.T) {
+	blocks := newBlocks(t, 5)
+
+	dir := t.TempDir()
+
+	log := openLog(t, dir, 1)
+	saveBlocks(t, log, blocks)
+
+	if err := log.Prune(3, database.Snapshot{Header: blocks[3].Header}); err != nil {
+		t.Fatalf("pruning: %s", err)
+	}
+	closeLog(t, log)
+
+	// The recovery reads a bad record at the end of a segment as a write
+	// that didn't finish, but it must not cut the log below the snapshot.
+	flipByte(t, segmentName(dir, 2), 20)
+
+	if _, err := storage.NewLogStorage(dir, 1, nil); !errors.Is(err, storage.ErrBelowSnapshot) {
+		t.Fatalf("opening: expected ErrBelowSnapshot, got %v", err)
+	}
+
+	for segment := 0; segment < len(blocks); segment++ {
+		if _, err := os.Stat(segmentName(dir, segment)); err != nil {
+			t.Errorf("segment %d: %s", segment, err)
+		}
+	}
+}
+
+// =============================================================================
+
+// newBlocks mines a chain of blocks with a transaction in each.
+func newBlocks(t *testing.T, n int) []database.Block {
+	t.Helper()
+
+	key, err := crypto.HexToECDSA("fae85851bdf5c9f49923722ce38f3c1defcfd3619ef5453230a58ad805499959")
+	if err != nil {
+		t.Fatalf("loading key: %s", err)
+	}
+
+	from, err := database.PublicKeyToAccountID(key.PublicKey)
+	if err != nil {
+		t.Fatalf("converting key: %s", err)
+	}
+
+	var blocks []database.Block
+	var prev database.Block
+	for i := 1; i <= n; i++ {
+		tx, err := database.NewTx(from, "0xbEE6ACE826eC3DE1B6349888B9151B92522F7F76", 10, 1, 1, nil, uint64(i))
+		if err != nil {
+			t.Fatalf("constructing transaction: %s", err)
+		}
+
+		signedTx, err := tx.Sign(key)
+		if err != nil {
+			t.Fatalf("signing transaction: %s", err)
+		}
+
+		block, err := database.POW(context.Background(), database.POWArgs{
+			BeneficiaryID: "0xFef311483Cc040e1A89fb9bb469eeB8A70935EF8",
+			Difficulty:    1,
+			MiningReward:  700,
+			PrevBlock:     prev,
+			Trans:         []database.BlockTx{database.NewBlockTx(signedTx, 15, 1)},
+			Workers:       1,
+			TimeStamp:     prev.Header.TimeStamp + 1,
+			EvHandler:     func(v string, args ...any) {},
+		})
+		if err != nil {
+			t.Fatalf("mining block: %s", err)
+		}
+
+		blocks = append(blocks, block)
+		prev = block
+	}
+
+	return blocks
+}
+
+// openLog opens the log in the folder.
+func openLog(t *testing.T, dir string, segmentSize int64) *storage.LogStorage {
+	t.Helper()
+
+	log, err := storage.NewLogStorage(dir, segmentSize, nil)
+	if err != nil {
+		t.Fatalf("opening log: %s", err)
+	}
+
+	return log
+}
+
+// closeLog closes the log.
+func closeLog(t *testing.T, log *storage.LogStorage) {
+	t.Helper()
+
+	if err := log.Close(); err != nil {
+		t.Fatalf("closing log: %s", err)
+	}
+}
+
+// saveBlocks saves the blocks in order.
+func saveBlocks(t *testing.T, st database.Storage, blocks []database.Block) {
+	t.Helper()
+
+	for _, block := range blocks {
+		if err := st.Save(block); err != nil {
+			t.Fatalf("saving block %d: %s", block.Header.Number, err)
+		}
+	}
+}
+
+// checkBlocks checks the storage holds exactly the blocks, both when they are
+// found one at a time and when they are iterated.
+func checkBlocks(t *testing.T, st database.Storage, blocks []database.Block) {
+	t.Helper()
+
+	for _, block := range blocks {
+		found, err := st.Find(block.Header.Number)
+		if err != nil {
+			t.Fatalf("finding block %d: %s", block.Header.Number, err)
+		}
+		if found.Hash() != block.Hash() {
+			t.Errorf("block %d: got hash %s, expected %s", block.Header.Number, found.Hash(), block.Hash())
+		}
+	}
+
+	if _, err := st.Find(uint64(len(blocks)) + 1); err == nil {
+		t.Errorf("found block %d after the last one", len(blocks)+1)
+	}
+
+	checkRange(t, st, 1, database.Latest, blocks)
+}
+
+// checkRange checks iterating the range returns the blocks in order.
+func checkRange(t *testing.T, st database.Storage, from, to uint64, blocks []database.Block) {
+	t.Helper()
+
+	var got []database.Block
+	it := st.Iterate(from, to)
+	for it.Next() {
+		got = append(got, it.Block())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("iterating from %d to %d: %s", from, to, err)
+	}
+
+	if len(got) != len(blocks) {
+		t.Fatalf("iterating from %d to %d: got %d blocks, expected %d", from, to, len(got), len(blocks))
+	}
+	for i := range blocks {
+		if got[i].Hash() != blocks[i].Hash() {
+			t.Errorf("iterating from %d to %d: block %d: got %d, expected %d", from, to, i, got[i].Header.Number, blocks[i].Header.Number)
+		}
+	}
+}
+
+// segmentName returns the file name of the segment in the folder.
+func segmentName(dir string, segment int) string {
+	return filepath.Join(dir, fmt.Sprintf("%010d.seg", segment))
+}
+
+// recordOffsets returns where every record of the segment starts, reading the
+// length in the header of each record.
+func recordOffsets(t *testing.T, name string) []int64 {
+	t.Helper()
+
+	content, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("reading segment: %s", err)
+	}
+
+	var offsets []int64
+	for offset := 0; offset+8 <= len(content); {
+		offsets = append(offsets, int64(offset))
+		offset += 8 + int(binary.BigEndian.Uint32(content[offset:offset+4]))
+	}
+
+	return offsets
+}
+
+// cut truncates the file to the specified size, like a write that didn't
+// finish.
+func cut(t *testing.T, name string, size int64) {
+	t.Helper()
+
+	if err := os.Truncate(name, size); err != nil {
+		t.Fatalf("truncating %s: %s", name, err)
+	}
+}
+
+// flipByte flips the bits of the byte at the offset of the file.
+func flipByte(t *testing.T, name string, offset int64) {
+	t.Helper()
+
+	content, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("reading %s: %s", name, err)
+	}
+
+	content[offset] ^= 0xff
+
+	if err := os.WriteFile(name, content, 0644); err != nil {
+		t.Fatalf("writing %s: %s", name, err)
+	}
+}
+
+// checkPruned checks the blocks up to the number are found without their
+// transactions, but with their headers, and the blocks after it are found
+// whole.
+func checkPruned(t *testing.T, log *storage.LogStorage, blocks []database.Block, pruned uint64) {
+	t.Helper()
+
+	for _, block := range blocks {
+		number := block.Header.Number
+
+		header, err := log.FindHeader(number)
+		if err != nil {
+			t.Fatalf("block %d: finding header: %s", number, err)
+		}
+		if hash := (database.Block{Header: header}).Hash(); hash != block.Hash() {
+			t.Errorf("block %d: got header hash %s, expected %s", number, hash, block.Hash())
+		}
+
+		found, err := log.Find(number)
+		switch {
+		case number <= pruned:
+			if !errors.Is(err, database.ErrPruned) {
+				t.Errorf("block %d: expected a pruned block, got %v", number, err)
+			}
+		case err != nil:
+			t.Errorf("block %d: finding block: %s", number, err)
+		case found.MerkleTree.RootHex() != block.MerkleTree.RootHex():
+			t.Errorf("block %d: transactions changed", number)
+		}
+	}
+
+	it := log.Iterate(1, database.Latest)
+	for it.Next() {
+	}
+	if err := it.Err(); (pruned > 0) != errors.Is(err, database.ErrPruned) {
+		t.Errorf("iterating the whole log: got %v", err)
+	}
+}
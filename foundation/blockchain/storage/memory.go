@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"sync"
+
+	"emperror.dev/errors"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+)
+
+// MemoryStorage keeps the blocks in memory. Nothing survives a restart, so
+// it's meant for development and testing.
+type MemoryStorage struct {
+	mu     sync.RWMutex
+	blocks map[uint64]database.Block
+}
+
+// NewMemoryStorage constructs an empty memory storage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		blocks: make(map[uint64]database.Block),
+	}
+}
+
+func (m *MemoryStorage) Save(block database.Block) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.blocks[block.Header.Number] = block
+
+	return nil
+}
+
+func (m *MemoryStorage) Delete(blockNumber uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.blocks[blockNumber]; !exists {
+		return errors.Errorf("Block %d not found", blockNumber)
+	}
+	delete(m.blocks, blockNumber)
+
+	return nil
+}
+
+func (m *MemoryStorage) Find(blockNumber uint64) (database.Block, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	block, exists := m.blocks[blockNumber]
+	if !exists {
+		return database.Block{}, errors.Errorf("Block %d not found", blockNumber)
+	}
+
+	return block, nil
+}
+
+// Iterate returns an iterator over the blocks between the specified numbers.
+func (m *MemoryStorage) Iterate(from, to uint64) database.Iterator {
+	m.mu.RLock()
+	var latest uint64
+	for number := range m.blocks {
+		if number > latest {
+			latest = number
+		}
+	}
+	m.mu.RUnlock()
+
+	return newIterator(from, to, latest, m.Find)
+}
+
+// Close releases the storage. The blocks are lost.
+func (m *MemoryStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"emperror.dev/errors"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+)
+
+// snapshotName is the file the snapshot of a pruned node is kept in, next to
+// the blocks.
+const snapshotName = "snapshot"
+
+// ErrBelowSnapshot is returned when blocks would be dropped down to the block
+// the snapshot was taken at. The chain is replayed from that block, so a node
+// without it can't start and has to resync from its peers instead.
+var ErrBelowSnapshot = errors.New("blocks up to the snapshot can't be dropped, the node must resync")
+
+// snapshotFile is what's written to the snapshot file. Every block before
+// PrunedBefore had its transactions dropped, so pruning doesn't revisit them.
+type snapshotFile struct {
+	Checksum     string          `json:"checksum"`
+	PrunedBefore uint64          `json:"pruned_before"`
+	Snapshot     json.RawMessage `json:"snapshot"`
+}
+
+// writeSnapshot replaces the snapshot file in the folder.
+func writeSnapshot(folderName string, snapshot database.Snapshot, prunedBefore uint64) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return errors.Wrap(err, "Error while marshalling snapshot")
+	}
+
+	marshal, err := json.Marshal(snapshotFile{
+		Checksum:     checksum(data),
+		PrunedBefore: prunedBefore,
+		Snapshot:     data,
+	})
+	if err != nil {
+		return errors.Wrap(err, "Error while marshalling snapshot file")
+	}
+
+	return writeFile(filepath.Join(folderName, snapshotName), marshal)
+}
+
+// readSnapshot reads the snapshot file in the folder. It reports false when
+// the node was never pruned.
+func readSnapshot(folderName string) (database.Snapshot, uint64, bool, error) {
+	content, err := os.ReadFile(filepath.Join(folderName, snapshotName))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return database.Snapshot{}, 0, false, nil
+	case err != nil:
+		return database.Snapshot{}, 0, false, errors.Wrap(err, "Error while reading snapshot")
+	}
+
+	var sf snapshotFile
+	if err := json.Unmarshal(content, &sf); err != nil {
+		return database.Snapshot{}, 0, false, errors.Wrap(err, "Error while decoding snapshot file")
+	}
+
+	if sf.Checksum != checksum(sf.Snapshot) {
+		return database.Snapshot{}, 0, false, errors.New("Snapshot checksum mismatch")
+	}
+
+	var snapshot database.Snapshot
+	if err := json.Unmarshal(sf.Snapshot, &snapshot); err != nil {
+		return database.Snapshot{}, 0, false, errors.Wrap(err, "Error while decoding snapshot")
+	}
+
+	return snapshot, sf.PrunedBefore, true, nil
+}
+
+// checkSnapshot returns ErrBelowSnapshot when dropping the specified block and
+// every block after it would drop the block the snapshot was taken at.
+func checkSnapshot(folderName string, blockNumber uint64) error {
+	snapshot, _, exists, err := readSnapshot(folderName)
+	if err != nil {
+		return err
+	}
+
+	if exists && blockNumber <= snapshot.Header.Number {
+		return fmt.Errorf("dropping block %d, snapshot is at block %d: %w", blockNumber, snapshot.Header.Number, ErrBelowSnapshot)
+	}
+
+	return nil
+}
@@ -1,91 +1,340 @@
 package storage
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 
 	"emperror.dev/errors"
 
 	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
 )
 
-type DiskStorage struct {
-	folderName string
+// ErrCorrupted is returned, wrapped in a CorruptedError, when a stored block
+// can't be read back as the block that was saved.
+var ErrCorrupted = errors.New("stored block is corrupted")
+
+// CorruptedError reports the block that is corrupted and why.
+type CorruptedError struct {
+	Number uint64
+	Reason string
 }
 
-type byBlockNumber []database.Block
+func (e *CorruptedError) Error() string {
+	return fmt.Sprintf("%s: block %d: %s", ErrCorrupted, e.Number, e.Reason)
+}
 
-func (b byBlockNumber) Less(i, j int) bool {
-	return b[i].Header.Number < b[j].Header.Number
+// Is allows errors.Is(err, ErrCorrupted) to match.
+func (e *CorruptedError) Is(target error) bool {
+	return target == ErrCorrupted
 }
 
-func (b byBlockNumber) Swap(i, j int) {
-	b[i], b[j] = b[j], b[i]
+// tmpSuffix marks the files a block is written to before it's renamed into
+// place. They are leftovers of a crash when found on disk.
+const tmpSuffix = ".tmp"
+
+type DiskStorage struct {
+	folderName   string
+	prunedBefore uint64
+
+	// The directory is only listed when the storage is opened. The number of
+	// the latest block is kept up to date from there, so iterating doesn't
+	// list every block file.
+	mu     sync.RWMutex
+	latest uint64
 }
 
-func (b byBlockNumber) Len() int {
-	return len(b)
+// blockFile is what's written to every block file. The checksum is the
+// SHA256 of the block bytes, so a partial or damaged write is detected.
+type blockFile struct {
+	Checksum string          `json:"checksum"`
+	Block    json.RawMessage `json:"block"`
 }
 
-func NewDiskStorage(folderName string) *DiskStorage {
-	return &DiskStorage{
-		folderName: folderName,
+func NewDiskStorage(folderName string) (*DiskStorage, error) {
+	if err := os.MkdirAll(folderName, 0755); err != nil {
+		return nil, errors.Wrap(err, "Error while creating directory")
+	}
+
+	_, prunedBefore, _, err := readSnapshot(folderName)
+	if err != nil {
+		return nil, err
 	}
+
+	d := DiskStorage{
+		folderName:   folderName,
+		prunedBefore: prunedBefore,
+	}
+
+	numbers, err := d.blockNumbers()
+	if err != nil {
+		return nil, err
+	}
+	if n := len(numbers); n > 0 {
+		d.latest = numbers[n-1]
+	}
+
+	return &d, nil
 }
 
+// Save writes the block to a temporary file which is flushed to disk and then
+// renamed over the block file. A crash leaves either the old file or the new
+// one, never a mix of both.
 func (d *DiskStorage) Save(block database.Block) error {
-	filename := d.folderName + "/" + strconv.FormatInt(int64(block.Header.Number), 10)
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY, 0644)
-	defer file.Close()
+	if err := d.writeBlockData(block.Header.Number, database.NewBlockData(block)); err != nil {
+		return err
+	}
 
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if block.Header.Number > d.latest {
+		d.latest = block.Header.Number
+	}
+
+	return nil
+}
+
+// Delete removes the block file. The blocks up to the snapshot of a pruned
+// node can't be deleted.
+func (d *DiskStorage) Delete(blockNumber uint64) error {
+	if err := checkSnapshot(d.folderName, blockNumber); err != nil {
+		return err
+	}
+
+	err := os.Remove(d.filename(blockNumber))
 	if err != nil {
-		return errors.Wrap(err, "Error while opening file")
+		return errors.Wrap(err, "Error while deleting file")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if blockNumber == d.latest {
+		d.latest--
 	}
 
-	marshal, err := json.Marshal(block)
+	return nil
+}
+
+// Find reads the block with the specified number. A file that doesn't match
+// its checksum or can't be decoded returns a CorruptedError. A pruned block
+// returns database.ErrPruned.
+func (d *DiskStorage) Find(blockNumber uint64) (database.Block, error) {
+	blockData, err := d.readBlockData(blockNumber)
 	if err != nil {
-		return errors.Wrap(err, "Error while marshalling block")
+		return database.Block{}, err
 	}
 
-	_, err = file.Write(marshal)
+	if blockData.Pruned {
+		return database.Block{}, fmt.Errorf("block %d: %w", blockNumber, database.ErrPruned)
+	}
+
+	block, err := database.ToBlock(blockData)
 	if err != nil {
-		return errors.Wrap(err, "Error while writing block")
+		return database.Block{}, &CorruptedError{Number: blockNumber, Reason: err.Error()}
 	}
 
+	return block, nil
+}
+
+// FindHeader reads the header of the block with the specified number, which
+// is kept when the block is pruned.
+func (d *DiskStorage) FindHeader(blockNumber uint64) (database.BlockHeader, error) {
+	blockData, err := d.readBlockData(blockNumber)
+	if err != nil {
+		return database.BlockHeader{}, err
+	}
+
+	return blockData.Header, nil
+}
+
+// Prune writes the snapshot and then rewrites the blocks before the specified
+// number without their transactions.
+func (d *DiskStorage) Prune(before uint64, snapshot database.Snapshot) error {
+	if err := writeSnapshot(d.folderName, snapshot, d.prunedBefore); err != nil {
+		return err
+	}
+
+	for blockNumber := d.prunedBefore; blockNumber < before; blockNumber++ {
+		if blockNumber == 0 {
+			continue
+		}
+
+		blockData, err := d.readBlockData(blockNumber)
+		if err != nil {
+			return err
+		}
+		if blockData.Pruned {
+			continue
+		}
+
+		if err := d.writeBlockData(blockNumber, database.NewPrunedBlockData(blockData.Header)); err != nil {
+			return err
+		}
+	}
+
+	if err := writeSnapshot(d.folderName, snapshot, before); err != nil {
+		return err
+	}
+	d.prunedBefore = before
+
 	return nil
 }
 
-func (d *DiskStorage) Delete(blockNumber uint64) error {
-	filename := d.folderName + "/" + strconv.FormatInt(int64(blockNumber), 10)
-	err := os.Remove(filename)
+// Snapshot returns the snapshot written by the last prune, if any.
+func (d *DiskStorage) Snapshot() (database.Snapshot, bool, error) {
+	snapshot, _, exists, err := readSnapshot(d.folderName)
+	return snapshot, exists, err
+}
+
+// Iterate returns an iterator over the blocks between the specified numbers.
+// A block missing in the range is reported as corrupted, since the blocks
+// after it can't be replayed.
+func (d *DiskStorage) Iterate(from, to uint64) database.Iterator {
+	d.mu.RLock()
+	latest := d.latest
+	d.mu.RUnlock()
+
+	return newIterator(from, to, latest, func(blockNumber uint64) (database.Block, error) {
+		block, err := d.Find(blockNumber)
+		if errors.Is(err, os.ErrNotExist) {
+			return database.Block{}, &CorruptedError{Number: blockNumber, Reason: "block file is missing"}
+		}
+		return block, err
+	})
+}
+
+// Truncate deletes the first missing or corrupted block and every block after
+// it, along with the temporary files left by a crash. It returns the number
+// of the last good block, zero when there is none. Nothing is deleted when
+// the bad block is at or below the snapshot of a pruned node, which returns
+// ErrBelowSnapshot.
+func (d *DiskStorage) Truncate() (uint64, error) {
+	numbers, err := d.blockNumbers()
 	if err != nil {
-		return errors.Wrap(err, "Error while deleting file")
+		return 0, err
 	}
 
+	var lastGood uint64
+	for i, blockNumber := range numbers {
+		if blockNumber != uint64(i+1) {
+			break
+		}
+		if _, err := d.readBlockData(blockNumber); err != nil {
+			break
+		}
+		lastGood = blockNumber
+	}
+
+	if lastGood < uint64(len(numbers)) {
+		if err := checkSnapshot(d.folderName, lastGood+1); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, blockNumber := range numbers[lastGood:] {
+		if err := d.Delete(blockNumber); err != nil {
+			return 0, err
+		}
+	}
+
+	d.mu.Lock()
+	d.latest = lastGood
+	d.mu.Unlock()
+
+	files, err := filepath.Glob(filepath.Join(d.folderName, "*"+tmpSuffix))
+	if err != nil {
+		return 0, errors.Wrap(err, "Error while listing temporary files")
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil {
+			return 0, errors.Wrap(err, "Error while deleting temporary file")
+		}
+	}
+
+	if err := syncDir(d.folderName); err != nil {
+		return 0, errors.Wrap(err, "Error while syncing directory")
+	}
+
+	return lastGood, nil
+}
+
+// Close releases the storage. Every block is written to its own file when
+// it's saved, so there is nothing left to flush.
+func (d *DiskStorage) Close() error {
 	return nil
 }
 
-func (d *DiskStorage) Find(blockNumber uint64) (database.Block, error) {
-	filename := d.folderName + "/" + strconv.FormatInt(int64(blockNumber), 10)
-	file, err := os.OpenFile(filename, os.O_RDONLY, 0644)
-	defer file.Close()
+// =============================================================================
 
+// writeBlockData writes the block file for the specified block number.
+func (d *DiskStorage) writeBlockData(blockNumber uint64, blockData database.BlockData) error {
+	data, err := json.Marshal(blockData)
 	if err != nil {
-		return database.Block{}, errors.Wrap(err, "Error while opening file")
+		return errors.Wrap(err, "Error while marshalling block")
 	}
 
-	var block database.Block
-	err = json.NewDecoder(file).Decode(&block)
+	marshal, err := json.Marshal(blockFile{
+		Checksum: checksum(data),
+		Block:    data,
+	})
 	if err != nil {
-		return database.Block{}, errors.Wrap(err, "Error while decoding block")
+		return errors.Wrap(err, "Error while marshalling block file")
 	}
 
-	return block, nil
+	return writeFile(d.filename(blockNumber), marshal)
+}
+
+// readBlockData reads the block file for the specified block number and
+// checks it's the block that was saved.
+func (d *DiskStorage) readBlockData(blockNumber uint64) (database.BlockData, error) {
+	content, err := os.ReadFile(d.filename(blockNumber))
+	if err != nil {
+		return database.BlockData{}, errors.Wrap(err, "Error while opening file")
+	}
+
+	var bf blockFile
+	if err := json.Unmarshal(content, &bf); err != nil {
+		return database.BlockData{}, &CorruptedError{Number: blockNumber, Reason: err.Error()}
+	}
+
+	// Files written before checksums were added hold the block data alone.
+	data := []byte(bf.Block)
+	switch {
+	case bf.Checksum == "" && len(bf.Block) == 0:
+		data = content
+	case bf.Checksum != checksum(data):
+		return database.BlockData{}, &CorruptedError{Number: blockNumber, Reason: "checksum mismatch"}
+	}
+
+	var blockData database.BlockData
+	if err := json.Unmarshal(data, &blockData); err != nil {
+		return database.BlockData{}, &CorruptedError{Number: blockNumber, Reason: err.Error()}
+	}
+
+	if blockData.Header.Number != blockNumber {
+		return database.BlockData{}, &CorruptedError{Number: blockNumber, Reason: fmt.Sprintf("file holds block %d", blockData.Header.Number)}
+	}
+
+	return blockData, nil
+}
+
+// filename returns the name of the file for the specified block.
+func (d *DiskStorage) filename(blockNumber uint64) string {
+	return filepath.Join(d.folderName, strconv.FormatUint(blockNumber, 10))
 }
 
-func (d *DiskStorage) List() ([]database.Block, error) {
+// blockNumbers returns the numbers of the block files in the folder sorted.
+// Temporary files are ignored.
+func (d *DiskStorage) blockNumbers() ([]uint64, error) {
 	dir, err := os.Open(d.folderName)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error while opening directory")
@@ -97,24 +346,75 @@ func (d *DiskStorage) List() ([]database.Block, error) {
 		return nil, errors.Wrap(err, "Error while reading directory")
 	}
 
-	var blocks []database.Block
+	var numbers []uint64
 	for _, file := range files {
-		if !file.IsDir() {
-			blockNumber, err := strconv.ParseUint(file.Name(), 10, 64)
-			if err != nil {
-				return nil, errors.Wrap(err, "Error while parsing block number")
-			}
-
-			block, err := d.Find(blockNumber)
-			if err != nil {
-				return nil, errors.Wrap(err, "Error while finding block")
-			}
-
-			blocks = append(blocks, block)
+		if file.IsDir() || file.Name() == snapshotName || strings.HasSuffix(file.Name(), tmpSuffix) {
+			continue
 		}
+
+		blockNumber, err := strconv.ParseUint(file.Name(), 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error while parsing block number")
+		}
+
+		numbers = append(numbers, blockNumber)
+	}
+
+	sort.Slice(numbers, func(i, j int) bool {
+		return numbers[i] < numbers[j]
+	})
+
+	return numbers, nil
+}
+
+// writeFile writes the data to a temporary file which is flushed to disk and
+// then renamed over the specified file.
+func writeFile(filename string, data []byte) error {
+	file, err := os.OpenFile(filename+tmpSuffix, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrap(err, "Error while opening file")
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return errors.Wrap(err, "Error while writing file")
 	}
 
-	sort.Sort(byBlockNumber(blocks))
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return errors.Wrap(err, "Error while syncing file")
+	}
+
+	if err := file.Close(); err != nil {
+		return errors.Wrap(err, "Error while closing file")
+	}
+
+	if err := os.Rename(filename+tmpSuffix, filename); err != nil {
+		return errors.Wrap(err, "Error while renaming file")
+	}
+
+	// The rename is only durable once the directory is flushed too.
+	if err := syncDir(filepath.Dir(filename)); err != nil {
+		return errors.Wrap(err, "Error while syncing directory")
+	}
+
+	return nil
+}
+
+// syncDir flushes the folder so created, renamed and deleted files survive a
+// crash.
+func syncDir(folderName string) error {
+	dir, err := os.Open(folderName)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
 
-	return blocks, nil
+// checksum returns the hex encoded SHA256 of the data.
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
 }
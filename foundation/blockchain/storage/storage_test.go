@@ -0,0 +1,255 @@
+package storage_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
+	"github.com/ardanlabs/blockchain/foundation/blockchain/storage"
+)
+
+func TestDiskStorageFind(t *testing.T) {
+	tests := []struct {
+		name    string
+		damage  func(t *testing.T, dir string, block database.Block)
+		corrupt bool // Find must return ErrCorrupted.
+	}{
+		{
+			name:   "checksummed file",
+			damage: func(t *testing.T, dir string, block database.Block) {},
+		},
+		{
+			name: "file written before checksums",
+			damage: func(t *testing.T, dir string, block database.Block) {
+				data, err := json.Marshal(database.NewBlockData(block))
+				if err != nil {
+					t.Fatalf("marshalling block: %s", err)
+				}
+				writeBlockFile(t, dir, block.Header.Number, data)
+			},
+		},
+		{
+			name: "checksum mismatch",
+			damage: func(t *testing.T, dir string, block database.Block) {
+				editBlockFile(t, dir, block.Header.Number, func(bf map[string]json.RawMessage) {
+					bf["checksum"] = json.RawMessage(`"00"`)
+				})
+			},
+			corrupt: true,
+		},
+		{
+			name: "block changed after its checksum",
+			damage: func(t *testing.T, dir string, block database.Block) {
+				other := block
+				other.Header.Nonce++
+				data, err := json.Marshal(database.NewBlockData(other))
+				if err != nil {
+					t.Fatalf("marshalling block: %s", err)
+				}
+				editBlockFile(t, dir, block.Header.Number, func(bf map[string]json.RawMessage) {
+					bf["block"] = data
+				})
+			},
+			corrupt: true,
+		},
+		{
+			name: "torn file",
+			damage: func(t *testing.T, dir string, block database.Block) {
+				name := filepath.Join(dir, strconv.FormatUint(block.Header.Number, 10))
+				info, err := os.Stat(name)
+				if err != nil {
+					t.Fatalf("reading block file: %s", err)
+				}
+				cut(t, name, info.Size()/2)
+			},
+			corrupt: true,
+		},
+		{
+			name: "file holding another block",
+			damage: func(t *testing.T, dir string, block database.Block) {
+				content, err := os.ReadFile(filepath.Join(dir, "1"))
+				if err != nil {
+					t.Fatalf("reading block file: %s", err)
+				}
+				writeBlockFile(t, dir, block.Header.Number, content)
+			},
+			corrupt: true,
+		},
+	}
+
+	blocks := newBlocks(t, 3)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			disk := openDisk(t, dir)
+			saveBlocks(t, disk, blocks)
+
+			block := blocks[2]
+			tt.damage(t, dir, block)
+
+			found, err := disk.Find(block.Header.Number)
+
+			switch {
+			case tt.corrupt:
+				if !errors.Is(err, storage.ErrCorrupted) {
+					t.Errorf("expected a corrupted block, got %v", err)
+				}
+			case err != nil:
+				t.Errorf("finding block: %s", err)
+			case found.Hash() != block.Hash():
+				t.Errorf("got hash %s, expected %s", found.Hash(), block.Hash())
+			}
+		})
+	}
+}
+
+func TestDiskStorageTruncate(t *testing.T) {
+	tests := []struct {
+		name     string
+		damage   func(t *testing.T, dir string)
+		lastGood uint64
+	}{
+		{
+			name:     "nothing corrupted",
+			damage:   func(t *testing.T, dir string) {},
+			lastGood: 5,
+		},
+		{
+			name: "leftover temporary files",
+			damage: func(t *testing.T, dir string) {
+				for _, name := range []string{"3.tmp", "6.tmp"} {
+					if err := os.WriteFile(filepath.Join(dir, name), []byte(`{"checksum":`), 0644); err != nil {
+						t.Fatalf("writing temporary file: %s", err)
+					}
+				}
+			},
+			lastGood: 5,
+		},
+		{
+			name: "corrupted block in the middle",
+			damage: func(t *testing.T, dir string) {
+				editBlockFile(t, dir, 3, func(bf map[string]json.RawMessage) {
+					bf["checksum"] = json.RawMessage(`"00"`)
+				})
+			},
+			lastGood: 2,
+		},
+		{
+			name: "missing block in the middle",
+			damage: func(t *testing.T, dir string) {
+				if err := os.Remove(filepath.Join(dir, "4")); err != nil {
+					t.Fatalf("removing block file: %s", err)
+				}
+			},
+			lastGood: 3,
+		},
+		{
+			name: "torn last block",
+			damage: func(t *testing.T, dir string) {
+				cut(t, filepath.Join(dir, "5"), 10)
+			},
+			lastGood: 4,
+		},
+		{
+			name: "corrupted first block",
+			damage: func(t *testing.T, dir string) {
+				cut(t, filepath.Join(dir, "1"), 0)
+			},
+			lastGood: 0,
+		},
+	}
+
+	blocks := newBlocks(t, 5)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			disk := openDisk(t, dir)
+			saveBlocks(t, disk, blocks)
+
+			tt.damage(t, dir)
+
+			lastGood, err := disk.Truncate()
+			if err != nil {
+				t.Fatalf("truncating: %s", err)
+			}
+			if lastGood != tt.lastGood {
+				t.Errorf("last good block: got %d, expected %d", lastGood, tt.lastGood)
+			}
+
+			tmps, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+			if err != nil {
+				t.Fatalf("listing temporary files: %s", err)
+			}
+			if len(tmps) != 0 {
+				t.Errorf("temporary files left: %v", tmps)
+			}
+
+			checkBlocks(t, disk, blocks[:tt.lastGood])
+
+			for number := tt.lastGood + 1; number <= uint64(len(blocks)); number++ {
+				if _, err := os.Stat(filepath.Join(dir, strconv.FormatUint(number, 10))); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("block file %d wasn't deleted: %v", number, err)
+				}
+			}
+
+			// The dropped blocks can be saved again.
+			saveBlocks(t, disk, blocks[tt.lastGood:])
+			checkBlocks(t, disk, blocks)
+		})
+	}
+}
+
+// =============================================================================
+
+// openDisk opens the disk storage in the folder.
+func openDisk(t *testing.T, dir string) *storage.DiskStorage {
+	t.Helper()
+
+	disk, err := storage.NewDiskStorage(dir)
+	if err != nil {
+		t.Fatalf("opening disk storage: %s", err)
+	}
+
+	return disk
+}
+
+// writeBlockFile replaces the content of the file of the block.
+func writeBlockFile(t *testing.T, dir string, blockNumber uint64, content []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(dir, strconv.FormatUint(blockNumber, 10)), content, 0644); err != nil {
+		t.Fatalf("writing block file: %s", err)
+	}
+}
+
+// editBlockFile changes the fields of the file of the block.
+func editBlockFile(t *testing.T, dir string, blockNumber uint64, edit func(bf map[string]json.RawMessage)) {
+	t.Helper()
+
+	content, err := os.ReadFile(filepath.Join(dir, strconv.FormatUint(blockNumber, 10)))
+	if err != nil {
+		t.Fatalf("reading block file: %s", err)
+	}
+
+	var bf map[string]json.RawMessage
+	if err := json.Unmarshal(content, &bf); err != nil {
+		t.Fatalf("decoding block file: %s", err)
+	}
+
+	edit(bf)
+
+	content, err = json.Marshal(bf)
+	if err != nil {
+		t.Fatalf("encoding block file: %s", err)
+	}
+
+	writeBlockFile(t, dir, blockNumber, content)
+}
@@ -0,0 +1,16 @@
+package worker
+
+// Metrics receives the counts of the mining work that didn't end up in the
+// chain.
+type Metrics interface {
+	AddStaleTemplates()            // A mining operation was abandoned because the tip changed.
+	AddOrphanedBlocks()            // A block was solved after a competing block was accepted.
+	AddWastedHashes(hashes uint64) // Hashes performed for stale templates and orphaned blocks.
+}
+
+// nopMetrics discards the metrics when the config has none.
+type nopMetrics struct{}
+
+func (nopMetrics) AddStaleTemplates()     {}
+func (nopMetrics) AddOrphanedBlocks()     {}
+func (nopMetrics) AddWastedHashes(uint64) {}
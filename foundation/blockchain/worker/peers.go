@@ -0,0 +1,88 @@
+package worker
+
+import (
+	"github.com/ardanlabs/blockchain/foundation/blockchain/peer"
+)
+
+// peerOperations announces this node to the known peers and then keeps
+// checking on them. Peers are asked for their status on every tick, which
+// tracks their health, finds new peers through the peers they know about
+// and catches up on blocks this node missed.
+func (w *Worker) peerOperations() {
+	w.ev("worker: peerOperations: G started")
+	defer w.ev("worker: peerOperations: G completed")
+
+	w.s.NetSendNodeAvailableToPeers(w.s.KnownExternalPeers())
+	w.cfg.Tracker.End()
+
+	tick, stop := w.cfg.Ticker(w.cfg.PeerUpdateInterval)
+	defer stop()
+
+	for {
+		select {
+		case <-tick:
+			w.runPeerUpdatesOperation()
+			w.cfg.Tracker.End()
+		case <-w.shutDown:
+			w.ev("worker: peerOperations: received shut down signal")
+			return
+		}
+	}
+}
+
+// runPeerUpdatesOperation asks every known peer for its status.
+func (w *Worker) runPeerUpdatesOperation() {
+	w.ev("worker: runPeerUpdatesOperation: started")
+	defer w.ev("worker: runPeerUpdatesOperation: completed")
+
+	for _, pr := range w.s.KnownExternalPeers() {
+		status, err := w.s.NetRequestPeerStatus(pr)
+		if err != nil {
+			w.ev("worker: runPeerUpdatesOperation: NetRequestPeerStatus: %s: ERROR: %s", pr.Host, err)
+
+			if w.s.KnownPeers.RecordFailure(pr, w.cfg.MaxPeerFailures) {
+				w.ev("viewer: worker: runPeerUpdatesOperation: peer[%s]: REMOVED: unreachable", pr.Host)
+			}
+			continue
+		}
+
+		w.addNewPeers(status.KnownPeers)
+
+		// A peer that serves blocks failing validation counts as failing,
+		// so it's eventually dropped instead of being retried forever.
+		if status.LatestBlockNumber > w.s.GetLastBlock().Header.Number {
+			w.ev("worker: runPeerUpdatesOperation: retrieving blocks: peer[%s]: blocks[%d -> %d]", pr.Host, w.s.GetLastBlock().Header.Number+1, status.LatestBlockNumber)
+
+			if err := w.s.NetRequestPeerBlocks(pr, status.LatestBlockNumber); err != nil {
+				w.ev("worker: runPeerUpdatesOperation: NetRequestPeerBlocks: %s: ERROR: %s", pr.Host, err)
+
+				if w.s.KnownPeers.RecordFailure(pr, w.cfg.MaxPeerFailures) {
+					w.ev("viewer: worker: runPeerUpdatesOperation: peer[%s]: REMOVED: invalid blocks", pr.Host)
+				}
+				continue
+			}
+		}
+
+		w.s.KnownPeers.RecordSuccess(pr, status)
+	}
+}
+
+// addNewPeers adds the peers this node didn't know about and lets them know
+// about this node.
+func (w *Worker) addNewPeers(knownPeers []peer.Peer) {
+	var added []peer.Peer
+	for _, pr := range knownPeers {
+		if pr.Match(w.s.Host) {
+			continue
+		}
+
+		if w.s.KnownPeers.Add(pr) {
+			w.ev("viewer: worker: addNewPeers: peer[%s]: ADDED", pr.Host)
+			added = append(added, pr)
+		}
+	}
+
+	if len(added) > 0 {
+		w.s.NetSendNodeAvailableToPeers(added)
+	}
+}
@@ -0,0 +1,17 @@
+package worker
+
+// Tracker counts the work handed to the worker goroutines that hasn't been
+// handled yet, so whatever drives the worker on a manual clock can tell when
+// it's idle. A tick counts as work from the moment the ticker delivers it,
+// so the ticker calls Begin and the worker calls End once the tick is
+// handled.
+type Tracker interface {
+	Begin() // Work was queued for a worker goroutine.
+	End()   // Queued work was handled or discarded.
+}
+
+// nopTracker discards the work when the config has no tracker.
+type nopTracker struct{}
+
+func (nopTracker) Begin() {}
+func (nopTracker) End()   {}
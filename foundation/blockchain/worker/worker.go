@@ -3,38 +3,131 @@ package worker
 import (
 	"context"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ardanlabs/blockchain/foundation/blockchain/database"
 	"github.com/ardanlabs/blockchain/foundation/blockchain/state"
 )
 
+// maxTxShareRequests is the number of transactions that can wait to be
+// shared before new ones are dropped.
+const maxTxShareRequests = 100
+
+// Config represents the settings for the worker. Zero values are replaced
+// with the defaults.
+type Config struct {
+	PeerUpdateInterval time.Duration    // How often the known peers are asked for their status.
+	MaxPeerFailures    int              // Failed requests in a row before a peer is removed.
+	MiningWorkers      int              // Goroutines searching for a nonce. Zero uses every CPU.
+	BlockInterval      time.Duration    // How often pending transactions are mined regardless of count. Zero disables it.
+	Now                func() time.Time // Clock used to time stamp blocks. Nil uses the system clock.
+	Ticker             TickerFunc       // Starts the timers of the worker. Nil uses the system clock.
+	Metrics            Metrics          // Receives the mining work that was wasted. Nil discards it.
+	Tracker            Tracker          // Counts the work the worker hasn't handled yet. Nil discards it.
+}
+
+// TickerFunc starts a ticker with the specified period. It returns the channel
+// the ticks are delivered on and a function that stops the ticker. The worker
+// calls Tracker.End once it handled a tick.
+type TickerFunc func(d time.Duration) (<-chan time.Time, func())
+
+// systemTicker is the TickerFunc for the system clock.
+func systemTicker(d time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(d)
+	return ticker.C, ticker.Stop
+}
+
+// Default values used when the config leaves a setting empty.
+const (
+	defaultPeerUpdateInterval = 10 * time.Second
+	defaultMaxPeerFailures    = 3
+)
+
 type Worker struct {
+	cfg          Config
+	mining       int32
+	paused       int32
+	wg           sync.WaitGroup
 	shutDown     chan struct{}
+	shutDownOnce sync.Once
 	startMining  chan bool
 	cancelMining chan bool
+	tipChanged   chan struct{}
+	txSharing    chan database.BlockTx
 
 	s  *state.State
 	ev state.EventHandler
 }
 
-func newWorker(s *state.State, handler state.EventHandler) *Worker {
+func newWorker(s *state.State, handler state.EventHandler, cfg Config) *Worker {
+	if cfg.PeerUpdateInterval <= 0 {
+		cfg.PeerUpdateInterval = defaultPeerUpdateInterval
+	}
+
+	if cfg.MaxPeerFailures <= 0 {
+		cfg.MaxPeerFailures = defaultMaxPeerFailures
+	}
+
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+
+	if cfg.Ticker == nil {
+		cfg.Ticker = systemTicker
+	}
+
+	if cfg.Metrics == nil {
+		cfg.Metrics = nopMetrics{}
+	}
+
+	if cfg.Tracker == nil {
+		cfg.Tracker = nopTracker{}
+	}
+
 	return &Worker{
-		startMining:  make(chan bool, 0),
+		cfg:          cfg,
+		shutDown:     make(chan struct{}),
+		startMining:  make(chan bool, 1),
 		cancelMining: make(chan bool, 0),
+		tipChanged:   make(chan struct{}, 1),
+		txSharing:    make(chan database.BlockTx, maxTxShareRequests),
 		s:            s,
 		ev:           handler,
 	}
 }
 
-func Init(s *state.State, ev state.EventHandler) {
-	worker := newWorker(s, ev)
+func Init(s *state.State, ev state.EventHandler, cfg Config) {
+	worker := newWorker(s, ev, cfg)
 	s.Worker = worker
 
+	worker.wg.Add(3)
+
+	// The first sync and announcing the node to its peers happen right
+	// after the goroutines start, so they count as work from here.
+	worker.cfg.Tracker.Begin()
+	worker.cfg.Tracker.Begin()
+
 	hasStarted := make(chan bool)
 	go func() {
+		defer worker.wg.Done()
 		hasStarted <- true
 		worker.Run()
 	}()
+	<-hasStarted
+
+	go func() {
+		defer worker.wg.Done()
+		hasStarted <- true
+		worker.shareTxOperations()
+	}()
+	<-hasStarted
+
+	go func() {
+		defer worker.wg.Done()
+		hasStarted <- true
+		worker.peerOperations()
+	}()
 
 	<-hasStarted
 	worker.ev("Worker started")
@@ -43,10 +136,46 @@ func Init(s *state.State, ev state.EventHandler) {
 }
 
 func (w *Worker) Run() {
+
+	// Catch up with the rest of the network before doing any mining so the
+	// blocks are built on top of the real chain.
+	w.Sync()
+
+	if w.s.MempoolLength() >= int64(w.s.GetGenesis().TransPerBlock) {
+		w.SignalStartMining()
+	}
+	w.cfg.Tracker.End()
+
+	// Consensus mechanisms that produce blocks on a schedule need to check
+	// regularly if it's this node's turn. A nil channel is never selected.
+	var tick <-chan time.Time
+	if interval := w.s.Consensus.Interval(); interval > 0 {
+		var stop func()
+		tick, stop = w.cfg.Ticker(interval)
+		defer stop()
+	}
+
+	// Mine whatever is pending on a timer so a lone transaction doesn't wait
+	// for the mempool to fill up.
+	var blockTick <-chan time.Time
+	if w.cfg.BlockInterval > 0 {
+		var stop func()
+		blockTick, stop = w.cfg.Ticker(w.cfg.BlockInterval)
+		defer stop()
+	}
+
 	for {
 		select {
 		case <-w.startMining:
-			w.mine()
+			w.mine(false)
+			w.cfg.Tracker.End()
+		case <-tick:
+			w.mine(true)
+			w.cfg.Tracker.End()
+		case <-blockTick:
+			w.ev("worker: Run: block interval reached")
+			w.mine(false)
+			w.cfg.Tracker.End()
 		case <-w.shutDown:
 			w.ev("Worker: Shutdown requested")
 			return
@@ -54,25 +183,65 @@ func (w *Worker) Run() {
 	}
 }
 
-func (w *Worker) mine() {
-	if w.s.MempoolLength() == 0 {
-		w.ev("No transactions in mempool")
+// mine produces the next block if the consensus allows it. Blocks are only
+// produced empty when allowEmpty is set, which is the case on the ticks of
+// the consensus mechanisms that produce blocks on a fixed interval.
+func (w *Worker) mine(allowEmpty bool) {
+	if w.IsPaused() || (!allowEmpty && w.s.MempoolLength() == 0) {
+		return
+	}
+
+	// A start signal can still be pending when the worker is shut down.
+	select {
+	case <-w.shutDown:
 		return
+	default:
 	}
 
+	// A tip change from before this point is already part of the template.
+	select {
+	case <-w.tipChanged:
+	default:
+	}
+
+	now := w.cfg.Now()
+	prevBlock, stateRoot, trans := w.s.BlockTemplate(w.s.GetGenesis().TransPerBlock)
+
+	if !w.s.Consensus.CanSeal(prevBlock, now) {
+		return
+	}
+
+	// The pending transactions may all be waiting for earlier nonces.
+	if !allowEmpty && len(trans) == 0 {
+		return
+	}
+
+	atomic.StoreInt32(&w.mining, 1)
+	defer atomic.StoreInt32(&w.mining, 0)
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	var attempts uint64
 	args := database.POWArgs{
 		BeneficiaryID: w.s.BeneficiaryID,
 		Difficulty:    w.s.GetGenesis().Difficulty,
 		MiningReward:  uint64(w.s.GetGenesis().MiningReward),
-		PrevBlock:     w.s.GetLastBlock(),
-		StateRoot:     w.s.GetStateRoot(),
-		Trans:         w.s.Mempool(),
+		PrevBlock:     prevBlock,
+		StateRoot:     stateRoot,
+		Trans:         trans,
+		Workers:       w.cfg.MiningWorkers,
+		Evidence:      w.s.PendingEvidence(),
+		TimeStamp:     uint64(now.UTC().UnixMilli()),
+		Attempts:      &attempts,
 		EvHandler:     w.ev,
 	}
 
+	// solved is set once the block is sealed, so the tip changing because
+	// of our own block isn't mistaken for a stale template. wasted is set
+	// when the hashes performed didn't produce a block in the chain.
+	var solved, wasted int32
+
 	wg := sync.WaitGroup{}
 	wg.Add(2)
 
@@ -81,7 +250,7 @@ func (w *Worker) mine() {
 			wg.Done()
 			cancel()
 		}()
-		block, err := database.POW(ctx, args)
+		block, err := w.s.Consensus.Seal(ctx, args)
 		if err != nil {
 			switch {
 			case ctx.Err() != nil:
@@ -91,14 +260,21 @@ func (w *Worker) mine() {
 			}
 			return
 		}
+		atomic.StoreInt32(&solved, 1)
 
 		w.ev("!!!! We ve mined block: %s !!!", block.Hash())
 
-		err = w.s.ValidateBlock(&block)
-		if err != nil {
-			w.ev("We ve mined invalid block, error message: %s", err.Error())
+		// The block is only proposed to the peers once it's part of our
+		// own chain. A peer's block may have been accepted since it was
+		// solved, which makes this block an orphan.
+		if err := w.s.UpdateBlock(&block); err != nil {
+			w.ev("worker: runMiningOperation: MINING: UpdateBlock: ERROR: %s", err.Error())
+			w.cfg.Metrics.AddOrphanedBlocks()
+			atomic.StoreInt32(&wasted, 1)
 			return
 		}
+
+		w.s.NetSendBlockToPeers(block)
 	}()
 
 	go func() {
@@ -106,37 +282,137 @@ func (w *Worker) mine() {
 			wg.Done()
 			cancel()
 		}()
-		select {
-		case <-w.cancelMining:
-			w.ev("worker: runMiningOperation: MINING: CANCEL: requested")
-		case <-ctx.Done():
-		case <-w.shutDown:
-			w.ev("worker: runMiningOperation: MINING: SHUTDOWN: requested")
+		for {
+			select {
+			case <-w.cancelMining:
+				w.ev("worker: runMiningOperation: MINING: CANCEL: requested")
+				return
+			case <-w.tipChanged:
+				if atomic.LoadInt32(&solved) == 1 || w.s.GetLastBlock().Hash() == prevBlock.Hash() {
+					continue
+				}
+				w.ev("viewer: worker: runMiningOperation: MINING: STALE: tip changed, rebuilding on blk[%d]", w.s.GetLastBlock().Header.Number)
+				w.cfg.Metrics.AddStaleTemplates()
+				atomic.StoreInt32(&wasted, 1)
+				return
+			case <-ctx.Done():
+				return
+			case <-w.shutDown:
+				w.ev("worker: runMiningOperation: MINING: SHUTDOWN: requested")
+				return
+			}
 		}
 	}()
 
 	wg.Wait()
 
+	if atomic.LoadInt32(&wasted) == 1 {
+		w.cfg.Metrics.AddWastedHashes(atomic.LoadUint64(&attempts))
+	}
+
 	if w.s.MempoolLength() > 0 {
 		w.ev("worker: runMiningOperation: MINING: More transactions in mempool")
 		w.SignalStartMining()
 	}
 }
 
+// Shutdown signals every worker goroutine to stop and waits for them. A
+// mining operation in progress is cancelled, but a block that was already
+// mined is still committed before this returns.
 func (w *Worker) Shutdown() {
-	w.shutDown <- struct{}{}
+	w.ev("worker: Shutdown: started")
+	defer w.ev("worker: Shutdown: completed")
+
+	w.shutDownOnce.Do(func() {
+		close(w.shutDown)
+	})
+
+	w.wg.Wait()
+
+	// The signals nobody is left to handle are discarded.
+	for {
+		select {
+		case <-w.startMining:
+			w.cfg.Tracker.End()
+		case <-w.txSharing:
+			w.cfg.Tracker.End()
+		default:
+			return
+		}
+	}
 }
 
+// Sync asks every known peer for its latest block and downloads the blocks
+// this node is missing. Peers that can't be reached are skipped. The node
+// is marked as synced once every peer has been tried. Forks aren't resolved,
+// so a peer whose chain split from this node's chain keeps failing.
 func (w *Worker) Sync() {
-	//TODO implement me
-	panic("implement me")
+	w.ev("worker: Sync: started")
+	defer w.ev("worker: Sync: completed")
+
+	for _, pr := range w.s.KnownExternalPeers() {
+		status, err := w.s.NetRequestPeerStatus(pr)
+		if err != nil {
+			w.ev("worker: Sync: NetRequestPeerStatus: %s: ERROR: %s", pr.Host, err)
+			continue
+		}
+
+		if status.LatestBlockNumber <= w.s.GetLastBlock().Header.Number {
+			continue
+		}
+
+		w.ev("worker: Sync: retrieving blocks: peer[%s]: blocks[%d -> %d]", pr.Host, w.s.GetLastBlock().Header.Number+1, status.LatestBlockNumber)
+
+		if err := w.s.NetRequestPeerBlocks(pr, status.LatestBlockNumber); err != nil {
+			w.ev("worker: Sync: NetRequestPeerBlocks: %s: ERROR: %s", pr.Host, err)
+
+			if w.s.KnownPeers.RecordFailure(pr, w.cfg.MaxPeerFailures) {
+				w.ev("viewer: worker: Sync: peer[%s]: REMOVED: invalid blocks", pr.Host)
+			}
+		}
+	}
+
+	w.s.MarkSynced()
+}
+
+// IsMining reports whether a mining operation is in progress.
+func (w *Worker) IsMining() bool {
+	return atomic.LoadInt32(&w.mining) == 1
+}
+
+// IsPaused reports whether mining was paused by an administrator.
+func (w *Worker) IsPaused() bool {
+	return atomic.LoadInt32(&w.paused) == 1
+}
+
+// PauseMining stops new mining operations from starting and cancels the one
+// in progress.
+func (w *Worker) PauseMining() {
+	atomic.StoreInt32(&w.paused, 1)
+	w.ev("viewer: worker: PauseMining: mining paused")
+
+	w.SignalCancelMining()
+}
+
+// ResumeMining allows mining again and starts right away if there are
+// transactions waiting.
+func (w *Worker) ResumeMining() {
+	atomic.StoreInt32(&w.paused, 0)
+	w.ev("viewer: worker: ResumeMining: mining resumed")
+
+	if w.s.MempoolLength() > 0 {
+		w.SignalStartMining()
+	}
 }
 
 func (w *Worker) SignalStartMining() {
+	w.cfg.Tracker.Begin()
+
 	select {
 	case w.startMining <- true:
 		w.ev("Start mining signal sent")
 	default:
+		w.cfg.Tracker.End()
 		w.ev("Start mining signal already sent")
 
 	}
@@ -144,10 +420,51 @@ func (w *Worker) SignalStartMining() {
 }
 
 func (w *Worker) SignalCancelMining() {
-	w.cancelMining <- true
+	select {
+	case w.cancelMining <- true:
+		w.ev("Cancel mining signal sent")
+	default:
+		w.ev("No mining operation to cancel")
+	}
+}
+
+// SignalTipChanged tells the mining operation in progress, if any, that a
+// new block was added to the chain. It never blocks.
+func (w *Worker) SignalTipChanged() {
+	select {
+	case w.tipChanged <- struct{}{}:
+	default:
+	}
 }
 
+// SignalShareTx queues a new mempool transaction to be shared with the
+// peers. The network calls happen on the sharing goroutine, so this never
+// blocks. If the queue is full the transaction isn't shared.
 func (w *Worker) SignalShareTx(blockTx database.BlockTx) {
-	//TODO implement me
-	panic("implement me")
+	w.cfg.Tracker.Begin()
+
+	select {
+	case w.txSharing <- blockTx:
+		w.ev("worker: SignalShareTx: share tx signaled: tx[%s]", blockTx)
+	default:
+		w.cfg.Tracker.End()
+		w.ev("worker: SignalShareTx: queue full, transaction won't be shared: tx[%s]", blockTx)
+	}
+}
+
+// shareTxOperations sends the queued transactions to the peers.
+func (w *Worker) shareTxOperations() {
+	w.ev("worker: shareTxOperations: G started")
+	defer w.ev("worker: shareTxOperations: G completed")
+
+	for {
+		select {
+		case tx := <-w.txSharing:
+			w.s.NetSendTxToPeers(tx)
+			w.cfg.Tracker.End()
+		case <-w.shutDown:
+			w.ev("worker: shareTxOperations: received shut down signal")
+			return
+		}
+	}
 }